@@ -20,6 +20,7 @@ func main() {
 	subcommands.Register(&cmd.Backup{}, "")
 	subcommands.Register(&cmd.Restore{}, "")
 	subcommands.Register(&cmd.Migrate{}, "")
+	subcommands.Register(&cmd.MigrateTopics{}, "")
 	subcommands.Register(&cmd.Demo{}, "")
 	subcommands.Register(&cmd.CreateAdmin{}, "")
 
@@ -26,6 +26,40 @@ func (s Database) Update(dbName string, collectionName string, id interface{}, v
 	return collection.UpdateByID(GetDbInstance().GetContext(), id, value)
 }
 
+func (s Database) Replace(dbName string, collectionName string, id interface{}, value interface{}) (*mongo.UpdateResult, error) {
+	client, _ := s.GetConnection()
+
+	db := client.Database(dbName)
+
+	collection := db.Collection(collectionName)
+
+	return collection.ReplaceOne(GetDbInstance().GetContext(), bson.M{"_id": id}, value)
+}
+
+// Upsert Replace document matched by filter or insert new one
+func (s Database) Upsert(dbName string, collectionName string, filter interface{}, value interface{}) (*mongo.UpdateResult, error) {
+	client, _ := s.GetConnection()
+
+	db := client.Database(dbName)
+
+	collection := db.Collection(collectionName)
+
+	return collection.ReplaceOne(GetDbInstance().GetContext(), filter, value, options.Replace().SetUpsert(true))
+}
+
+func (s Database) Get(dbName string, collectionName string, id interface{}) (bson.D, error) {
+	client, _ := s.GetConnection()
+
+	db := client.Database(dbName)
+
+	collection := db.Collection(collectionName)
+
+	var d bson.D
+	err := collection.FindOne(GetDbInstance().GetContext(), bson.M{"_id": id}).Decode(&d)
+
+	return d, err
+}
+
 func (s Database) Delete(dbName string, collectionName string, id interface{}) (*mongo.DeleteResult, error) {
 	client, _ := s.GetConnection()
 
@@ -36,6 +70,17 @@ func (s Database) Delete(dbName string, collectionName string, id interface{}) (
 	return collection.DeleteOne(GetDbInstance().GetContext(), bson.M{"_id": id})
 }
 
+// DeleteMany Remove documents matched by filter
+func (s Database) DeleteMany(dbName string, collectionName string, filter interface{}) (*mongo.DeleteResult, error) {
+	client, _ := s.GetConnection()
+
+	db := client.Database(dbName)
+
+	collection := db.Collection(collectionName)
+
+	return collection.DeleteMany(GetDbInstance().GetContext(), filter)
+}
+
 func (s Database) Insert(dbName string, collectionName string, value interface{}) (*mongo.InsertOneResult, error) {
 	client, _ := s.GetConnection()
 
@@ -114,6 +159,36 @@ func (s Database) List(dbName string, collectionName string, limit int64, skip i
 	return res, count, err
 }
 
+// Aggregate Run aggregation pipeline and decode all results
+func (s Database) Aggregate(dbName string, collectionName string, pipeline interface{}, results interface{}) error {
+	client, _ := s.GetConnection()
+
+	db := client.Database(dbName)
+
+	collection := db.Collection(collectionName)
+
+	var ctx = GetDbInstance().GetContext()
+
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+
+	return cur.All(ctx, results)
+}
+
+// MoveCollection Rename collection to another database
+func (s Database) MoveCollection(fromDb string, fromCollection string, toDb string, toCollection string) error {
+	client, _ := s.GetConnection()
+
+	cmd := bson.D{
+		{Key: "renameCollection", Value: fromDb + "." + fromCollection},
+		{Key: "to", Value: toDb + "." + toCollection},
+	}
+
+	return client.Database("admin").RunCommand(GetDbInstance().GetContext(), cmd).Err()
+}
+
 // Db Document oriented data base interface
 type Db interface {
 	GetConnection() (*mongo.Client, error)
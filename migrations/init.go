@@ -5,7 +5,9 @@ import (
 	"db-server/modules/cf"
 	"db-server/modules/config"
 	"db-server/modules/cron"
+	"db-server/modules/crypt"
 	"db-server/modules/ds"
+	"db-server/modules/hook"
 	"db-server/modules/oauth"
 	"db-server/modules/pipeline"
 	"db-server/modules/plugin"
@@ -15,6 +17,7 @@ import (
 	"db-server/modules/settings"
 	"db-server/modules/user"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func Migrate(db *gorm.DB) {
@@ -24,6 +27,8 @@ func Migrate(db *gorm.DB) {
 		&config.Config{},
 		&ds.DataSource{},
 		&ds.DataSourceEndpoint{},
+		&ds.SyncJob{},
+		&ds.SyncRun{},
 		&cf.CloudFunction{},
 		&cf.CloudFunctionLog{},
 		&models.PushMessage{},
@@ -35,7 +40,17 @@ func Migrate(db *gorm.DB) {
 		&settings.AppSettings{},
 		&rdb.Rdb{},
 		&plugin.Plugin{},
+		&hook.Hook{},
+		&crypt.ProjectKey{},
 	)
 
 	err2.PanicErr(err)
+
+	err2.WarnErr(ds.SealStoredDsns(db))
+
+	// hook runs were logged with pipeline trigger
+	err = db.Model(&cf.CloudFunctionLog{}).
+		Where(clause.Eq{Column: clause.Column{Name: "trigger"}, Value: "pipeline"}).
+		Update("trigger", cf.TriggerHook).Error
+	err2.WarnErr(err)
 }
@@ -0,0 +1,168 @@
+package cf
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"db-server/server"
+	"db-server/server/db"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	// Repository of images built from function sources, tagged with function id and version
+	buildRepository = "db-server/cf"
+	// Max size of unpacked sources in bytes
+	maxBuildContextSize = 500 << 20
+)
+
+// Dockerfile templates for sources without Dockerfile
+var runtimeTemplates = map[string]string{
+	"node": `FROM node:20-alpine
+WORKDIR /app
+COPY . .
+RUN if [ -f package.json ]; then npm install --omit=dev; fi
+CMD ["node", "index.js"]
+`,
+	"python": `FROM python:3.12-alpine
+WORKDIR /app
+COPY . .
+RUN if [ -f requirements.txt ]; then pip install --no-cache-dir -r requirements.txt; fi
+CMD ["python", "main.py"]
+`,
+	"go": `FROM golang:1.22-alpine AS build
+WORKDIR /src
+COPY . .
+RUN CGO_ENABLED=0 go build -o /function .
+FROM alpine
+COPY --from=build /function /function
+CMD ["/function"]
+`,
+}
+
+// Build Build function image from tar.gz sources, with own Dockerfile or runtime template.
+// Build output written to logs, function container switched to new image version on success
+func (p CloudFunction) Build(source io.Reader, runtime string, logs io.Writer) (string, error) {
+	buildContext, err := prepareBuildContext(source, runtime)
+	if err != nil {
+		return "", err
+	}
+
+	version, err := p.nextVersion()
+	if err != nil {
+		return "", err
+	}
+	image := fmt.Sprintf("%s/%s:v%d", buildRepository, p.Id, version)
+
+	if err := server.BuildDockerImage(buildContext, []string{image}, logs); err != nil {
+		return "", err
+	}
+
+	// image of build started later is not replaced
+	tx := db.MetaDb.GetConnection().Model(CloudFunction{}).Where("id = ? AND version = ?", p.Id, version).Update("container", image)
+	if tx.Error != nil {
+		return "", tx.Error
+	}
+	if tx.RowsAffected < 1 {
+		return "", errors.New("newer build of function started, image " + image + " not used")
+	}
+
+	return image, nil
+}
+
+// nextVersion Increment function version in meta db, concurrent builds get different versions
+func (p CloudFunction) nextVersion() (int, error) {
+	var current CloudFunction
+
+	err := db.MetaDb.GetConnection().Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(CloudFunction{}).Where("id = ?", p.Id).Update("version", gorm.Expr("version + 1")).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Select("version").First(&current, "id = ?", p.Id).Error
+	})
+
+	return current.Version, err
+}
+
+// isBuilt Function image built from sources, not pulled from registry
+func (p CloudFunction) isBuilt() bool {
+	return strings.HasPrefix(p.Container, buildRepository+"/")
+}
+
+// prepareBuildContext Repack tar.gz sources to docker build context, runtime Dockerfile added when sources have no own
+func prepareBuildContext(source io.Reader, runtime string) (io.Reader, error) {
+	gz, err := gzip.NewReader(source)
+	if err != nil {
+		return nil, errors.New("source is not tar.gz archive")
+	}
+
+	buf := new(bytes.Buffer)
+	in := tar.NewReader(gz)
+	out := tar.NewWriter(buf)
+	hasDockerfile := false
+	var size int64
+
+	for {
+		header, err := in.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean(header.Name)
+		if name == "." {
+			continue
+		}
+		if name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			return nil, errors.New("wrong file path " + header.Name)
+		}
+
+		if name == "Dockerfile" {
+			hasDockerfile = true
+		}
+
+		// archive size limits compressed data only
+		size += header.Size
+		if size > maxBuildContextSize {
+			return nil, errors.New("unpacked sources are larger than 500 MB")
+		}
+
+		header.Name = name
+		if err := out.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			return nil, err
+		}
+	}
+
+	if !hasDockerfile {
+		template, ok := runtimeTemplates[runtime]
+		if !ok {
+			return nil, errors.New("sources have no Dockerfile, runtime must be node, python or go")
+		}
+
+		err := out.WriteHeader(&tar.Header{Name: "Dockerfile", Mode: 0644, Size: int64(len(template))})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(out, template); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
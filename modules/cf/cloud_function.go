@@ -9,13 +9,14 @@ import (
 	"db-server/server/db"
 	"db-server/utils"
 	"errors"
+	"fmt"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
-	"io"
 	"strings"
 	"time"
 )
@@ -37,7 +38,30 @@ type CloudFunction struct {
 	// example: echo test
 	Params string `json:"params"`
 	// Container env variables
-	Env         string         `json:"env"`
+	Env string `json:"env"`
+	// Max simultaneous runs, further runs wait in queue. Unlimited when 0
+	// example: 2
+	MaxConcurrency int `json:"max_concurrency"`
+	// Container memory limit in MB, unlimited when 0
+	// example: 128
+	MemoryLimit int64 `json:"memory_limit"`
+	// Container CPU quota in cores, unlimited when 0
+	// example: 0.5
+	CpuLimit float64 `json:"cpu_limit"`
+	// Run timeout in seconds, container killed on expiry. 30 when 0, max 300
+	// example: 60
+	Timeout int `json:"timeout"`
+	// Container network: none or bridge, none when empty
+	// example: none
+	NetworkMode string `json:"network_mode"`
+	// Mount container root filesystem read only, /tmp stays writable
+	ReadOnly bool `json:"read_only"`
+	// Container user, nobody (65534:65534) when empty. Root is not allowed
+	// example: 1000:1000
+	User string `json:"user"`
+	// Version of image built from function sources
+	Version int `json:"version"`
+	// Shared container of previous versions, removed by containers cleanup
 	ContainerId string         `json:"-"`
 	CreatedAt   time.Time      `json:"-"`
 	UpdatedAt   time.Time      `json:"-"`
@@ -63,8 +87,32 @@ type CloudFunctionLog struct {
 	FunctionId uuid.UUID `json:"function_id"`
 	// Run date time
 	RunAt time.Time `json:"run_at"`
-	// Run result
+	// Run status: queued, running, succeeded, failed or timed_out
+	Status string `gorm:"index" json:"status"`
+	// Run source: api, cron or hook
+	Trigger string `json:"trigger"`
+	// Container start date time
+	StartedAt *time.Time `json:"started_at"`
+	// Run finish date time
+	FinishedAt *time.Time `json:"finished_at"`
+	// Run duration in milliseconds
+	Duration int64 `json:"duration"`
+	// Container exit code
+	ExitCode int64 `json:"exit_code"`
+	// Run result, container stdout
 	Result string `json:"result"`
+	// Container stderr
+	Stderr string `json:"stderr"`
+	// Size of stdout and stderr in bytes
+	OutputSize int64 `json:"output_size"`
+	// Run error
+	Error string `json:"error"`
+	// Violated resource limit: timeout or memory
+	Violation string `json:"violation"`
+	// Output larger than 4 MB, only its beginning kept
+	Truncated bool `json:"truncated"`
+	// Server host name of run
+	Instance string `gorm:"index" json:"instance"`
 }
 
 // TableName Gorm table name
@@ -72,12 +120,15 @@ func (p CloudFunctionLog) TableName() string {
 	return "cf_log"
 }
 
-func ListCfLog(fId uuid.UUID, limit int, offset int, sort string, order string) []interface{} {
+// ListCfLog Function runs, filtered by status when not empty
+func ListCfLog(fId uuid.UUID, status string, limit int, offset int, sort string, order string) []interface{} {
 	var sources []CloudFunctionLog
 
-	conn := db.MetaDb.GetConnection()
-
-	conn.Find(&sources, CloudFunctionLog{FunctionId: fId}).Limit(limit).Offset(offset).Order(clause.OrderByColumn{Column: clause.Column{Name: sort}, Desc: order != "ASC"})
+	logsQuery(fId, status).
+		Limit(limit).
+		Offset(offset).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: sort}, Desc: order != "ASC"}).
+		Find(&sources)
 
 	y := make([]interface{}, len(sources))
 	for i, v := range sources {
@@ -87,15 +138,22 @@ func ListCfLog(fId uuid.UUID, limit int, offset int, sort string, order string)
 	return y
 }
 
-func LogsTotal(fId uuid.UUID) *int64 {
-	conn := db.MetaDb.GetConnection()
-	var sources []CloudFunctionLog
+// LogsTotal Count of function runs, filtered by status when not empty
+func LogsTotal(fId uuid.UUID, status string) *int64 {
 	var cnt int64
-	conn.Count(&cnt).Find(&sources, CloudFunctionLog{FunctionId: fId})
+	logsQuery(fId, status).Count(&cnt)
 
 	return &cnt
 }
 
+func logsQuery(fId uuid.UUID, status string) *gorm.DB {
+	query := db.MetaDb.GetConnection().Model(&CloudFunctionLog{}).Where("function_id = ?", fId)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	return query
+}
+
 type ContainerUri struct {
 	Host    string
 	Vendor  string
@@ -126,6 +184,21 @@ func GetContainerUri(source string) (ContainerUri, error) {
 	return uri, nil
 }
 
+// runImage Image of function container. Built images run by full tag,
+// registry containers by image name as before function builds
+func (p CloudFunction) runImage() (string, error) {
+	if p.isBuilt() {
+		return p.Container, nil
+	}
+
+	uri, err := GetContainerUri(p.Container)
+	if err != nil {
+		return "", err
+	}
+
+	return uri.Image, nil
+}
+
 func (p CloudFunction) List(limit int, offset int, sort string, order string, filter map[string]string) ([]interface{}, error) {
 	var sources []CloudFunction
 
@@ -133,7 +206,7 @@ func (p CloudFunction) List(limit int, offset int, sort string, order string, fi
 
 	y := make([]interface{}, len(sources))
 	for i, v := range sources {
-		v.RunCount = *LogsTotal(v.Id)
+		v.RunCount = *LogsTotal(v.Id, "")
 		y[i] = v
 	}
 
@@ -157,7 +230,7 @@ func (p CloudFunction) GetById(id string) (interface{}, error) {
 
 	uid, _ := uuid.Parse(id)
 
-	source.RunCount = *LogsTotal(uid)
+	source.RunCount = *LogsTotal(uid, "")
 
 	return source, nil
 }
@@ -168,6 +241,11 @@ func (p CloudFunction) Delete(id string) {
 }
 
 func prepareDockerParams(raw string) []string {
+	// image command used without params
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+
 	parts := strings.Split(raw, "\\")
 	for i := range parts {
 		parts[i] = strings.TrimSpace(parts[i])
@@ -175,91 +253,236 @@ func prepareDockerParams(raw string) []string {
 	return parts
 }
 
-func (p CloudFunction) Run(runId uuid.UUID) {
+// Run Run function without payload in fresh container, used by cron jobs
+func (p CloudFunction) Run(runId uuid.UUID, trigger string) {
+	_, err := p.Invoke(runId, nil, 0, trigger)
+	err2.DebugErr(err)
+}
 
-	if p.ContainerId == "" {
-		uri, err := GetContainerUri(p.Container)
-		err2.WarnErr(err)
+// Start Queue function run and return without waiting its result, run record and output stream available at once
+func (p CloudFunction) Start(payload []byte, timeout time.Duration, trigger string) uuid.UUID {
+	runId, _ := uuid.NewUUID()
 
-		if err != nil {
-			return
-		}
+	p.queueRun(runId, trigger)
+	openRunStream(runId, p.Id)
 
-		cid, err := server.CreateDockerContainer(uri.Image, prepareDockerParams(p.Params), strings.Split(p.Env, "\n"))
-		err2.WarnErr(err)
+	go func() {
+		_, err := p.Invoke(runId, payload, timeout, trigger)
+		err2.DebugErr(err)
+	}()
 
-		if err != nil {
-			return
-		}
+	return runId
+}
+
+// Wait of container output end after container stop
+const logsDrainTimeout = 10 * time.Second
+
+// ErrRunTimeout Function container not exited in run timeout
+var ErrRunTimeout = errors.New("function run timed out")
+
+// swagger:model
+type RunResult struct {
+	// The run UUID
+	// example: 6204037c-30e6-408b-8aaa-dd8219860b4b
+	RunId uuid.UUID `json:"run_id"`
+	// Container stdout
+	Stdout string `json:"stdout"`
+	// Container stderr
+	Stderr string `json:"stderr"`
+	// Run status: succeeded, failed or timed_out
+	Status string `json:"status"`
+	// Container exit code
+	ExitCode int64 `json:"exit_code"`
+	// Run duration in milliseconds
+	Duration int64 `json:"duration"`
+	// Size of stdout and stderr in bytes
+	OutputSize int64 `json:"output_size"`
+	// Violated resource limit: timeout or memory
+	Violation string `json:"violation,omitempty"`
+	// Output larger than 4 MB, only its beginning kept
+	Truncated bool `json:"truncated,omitempty"`
+}
 
-		p.ContainerId = cid
+// Call Run function from topic event hooks in dedicated container and return its stdout.
+// Payload passed to container on stdin and in CF_PAYLOAD env variable, container removed after exit.
+// Non-zero exit code returned as error
+func (p CloudFunction) Call(payload []byte, timeout time.Duration) (string, error) {
+	runId, _ := uuid.NewUUID()
 
-		db.MetaDb.GetConnection().Model(CloudFunction{}).Where("id = ?", p.Id).Update("container_id", cid)
+	p.queueRun(runId, TriggerHook)
+	result, err := p.exec(runId, payload, timeout)
+	p.finishRun(runId, &result, err)
+
+	if err == nil && result.ExitCode != 0 {
+		return result.Stdout, fmt.Errorf("function exited with code %d: %s", result.ExitCode, result.Stderr)
 	}
 
-	ctx := context.Background()
-	cli, err := server.GetDockerCli()
+	return result.Stdout, err
+}
+
+// Invoke Run function in dedicated container with payload and wait its exit up to timeout, function timeout used when 0.
+// Result stored in function run record and passed to pipelines as Run does
+func (p CloudFunction) Invoke(runId uuid.UUID, payload []byte, timeout time.Duration, trigger string) (RunResult, error) {
+	p.queueRun(runId, trigger)
+
+	result, err := p.exec(runId, payload, timeout)
+	result.RunId = runId
+	p.finishRun(runId, &result, err)
 
-	if err := cli.ContainerStart(ctx, p.ContainerId, types.ContainerStartOptions{}); err != nil {
+	if err != nil {
 		err2.DebugErr(err)
-		p.log(runId, "error "+err.Error())
-		return
+		return result, err
 	}
 
-	statusCh, errCh := cli.ContainerWait(ctx, p.ContainerId, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
-		p.checkErr(runId, err)
-	case <-statusCh:
+	// output of failed run is not passed to pipelines
+	if result.Status == RunSucceeded {
+		pipeline.RunPipeline("func", p.Id, result.Stdout)
 	}
 
-	out, err := cli.ContainerLogs(ctx, p.ContainerId, types.ContainerLogsOptions{ShowStdout: log.GetLevel() >= log.InfoLevel})
-	p.checkErr(runId, err)
+	log.Debug("Cf run result " + runId.String() + " " + result.Stdout)
 
-	result, err := makeResultFromStream(out)
-	p.checkErr(runId, err)
+	return result, nil
+}
 
-	pipeline.RunPipeline("func", p.Id, result)
+// exec Create container with payload in CF_PAYLOAD env variable and on stdin, wait exit and collect output.
+// Timeout limited by function timeout, run waits free slot of function max concurrency within it.
+// Container runs with function resource limits and removed after exit or timeout
+func (p CloudFunction) exec(runId uuid.UUID, payload []byte, timeout time.Duration) (RunResult, error) {
+	var result RunResult
 
-	log.Debug("Cf run result " + runId.String() + " " + result)
+	stream := openRunStream(runId, p.Id)
+	defer stream.finish(runId)
 
-	p.log(runId, result)
-}
+	if limit := p.getTimeout(); timeout <= 0 || timeout > limit {
+		timeout = limit
+	}
 
-func makeResultFromStream(stream io.Reader) (string, error) {
-	buf := new(strings.Builder)
-	_, err := io.Copy(buf, stream)
+	// functions saved before sandbox checks are checked on run
+	if err := p.Validate(); err != nil {
+		return result, err
+	}
 
+	image, err := p.runImage()
 	if err != nil {
-		return "", err
+		return result, err
 	}
 
-	result := strings.TrimSpace(buf.String())
+	// time waiting free slot counts to run timeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	//see https://pkg.go.dev/github.com/pborman/ansi#pkg-constants
-	result = strings.ReplaceAll(result, "\001", "")
-	result = strings.ReplaceAll(result, "\000", "")
-	result = strings.ReplaceAll(result, "\005", "")
+	release, err := p.acquire(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer release()
+
+	env := append(strings.Split(p.Env, "\n"), "CF_PAYLOAD="+string(payload))
+
+	cid, err := server.CreateDockerContainerFromConfig(&container.Config{
+		Env:         env,
+		Image:       image,
+		Cmd:         prepareDockerParams(p.Params),
+		Labels:      p.runLabels(runId),
+		User:        p.runUser(),
+		AttachStdin: true,
+		OpenStdin:   true,
+		StdinOnce:   true,
+	}, p.hostConfig())
+	if err != nil {
+		return result, err
+	}
 
-	return result, nil
-}
+	cli, err := server.GetDockerCli()
+	if err != nil {
+		return result, err
+	}
 
-func (p CloudFunction) checkErr(id uuid.UUID, err error) {
+	defer func() {
+		err := cli.ContainerRemove(context.Background(), cid, types.ContainerRemoveOptions{Force: true})
+		err2.DebugErr(err)
+	}()
+
+	stdin, err := cli.ContainerAttach(ctx, cid, types.ContainerAttachOptions{Stream: true, Stdin: true})
 	if err != nil {
+		return result, err
+	}
+	defer stdin.Close()
+
+	started := time.Now()
+
+	if err := cli.ContainerStart(ctx, cid, types.ContainerStartOptions{}); err != nil {
+		return result, err
+	}
+	p.startRun(runId, started)
+
+	go func() {
+		_, err := stdin.Conn.Write(payload)
 		err2.DebugErr(err)
-		p.log(id, "error "+err.Error())
-		return
+		err2.DebugErr(stdin.CloseWrite())
+	}()
+
+	// output copied to live subscribers while container works
+	out, err := cli.ContainerLogs(context.Background(), cid, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: true})
+	if err != nil {
+		return result, err
 	}
-}
+	defer func() {
+		err := out.Close()
+		err2.DebugErr(err)
+	}()
+
+	logsDone := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(stream.writer("stdout"), stream.writer("stderr"), out)
+		logsDone <- err
+	}()
+
+	var runErr error
 
-func (p CloudFunction) log(id uuid.UUID, result string) {
-	flog := CloudFunctionLog{
-		FunctionId: p.Id,
-		RunAt:      time.Now(),
-		Result:     result,
-		Id:         id,
+	statusCh, errCh := cli.ContainerWait(ctx, cid, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return result, err
+		}
+		// output written before timeout is still collected
+		err = cli.ContainerKill(context.Background(), cid, "SIGKILL")
+		err2.DebugErr(err)
+		result.Violation = ViolationTimeout
+		runErr = ErrRunTimeout
+	case status := <-statusCh:
+		result.ExitCode = status.StatusCode
+		if status.Error != nil {
+			return result, errors.New(status.Error.Message)
+		}
 	}
-	var err error
-	err2.DebugErr(err)
-	db.MetaDb.GetConnection().Create(&flog)
+	result.Duration = time.Since(started).Milliseconds()
+
+	if runErr == nil {
+		info, err := cli.ContainerInspect(context.Background(), cid)
+		err2.DebugErr(err)
+		if err == nil && info.State != nil && info.State.OOMKilled {
+			result.Violation = ViolationMemory
+			runErr = ErrMemoryLimit
+		}
+	}
+
+	// follow stream ends after container stop
+	select {
+	case err = <-logsDone:
+	case <-time.After(logsDrainTimeout):
+		err = errors.New("container logs not finished")
+	}
+
+	stdout, stderr := stream.output("stdout"), stream.output("stderr")
+	result.OutputSize, result.Truncated = stream.size()
+	result.Stdout = strings.TrimSpace(stdout)
+	result.Stderr = strings.TrimSpace(stderr)
+
+	if runErr != nil {
+		return result, runErr
+	}
+
+	return result, err
 }
@@ -6,11 +6,24 @@ import (
 	"db-server/server/db"
 	"db-server/utils"
 	"encoding/json"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRunTimeout = 30
+	maxRunTimeout     = 300
+	// Max invocation payload size in bytes
+	maxPayloadSize = 1 << 20
+	// Max function sources archive size in bytes
+	maxSourceSize = 100 << 20
 )
 
 func AddAdminRoutes(admin *mux.Router) {
@@ -18,6 +31,8 @@ func AddAdminRoutes(admin *mux.Router) {
 	admin.HandleFunc("/cf", create).Methods(http.MethodPost, http.MethodOptions)            // each request calls PushHandler
 	admin.HandleFunc("/cf/{id}", item).Methods(http.MethodGet, http.MethodOptions)          // each request calls PushHandler
 	admin.HandleFunc("/cf/{id}/log", logs).Methods(http.MethodGet, http.MethodOptions)      // each request calls PushHandler
+	admin.HandleFunc("/cf/{id}/stats", stats).Methods(http.MethodGet, http.MethodOptions)   // each request calls PushHandler
+	admin.HandleFunc("/cf/{id}/build", build).Methods(http.MethodPost, http.MethodOptions)  // each request calls PushHandler
 	admin.HandleFunc("/cf/{id}", deleteItem).Methods(http.MethodDelete, http.MethodOptions) // each request calls PushHandler
 	admin.HandleFunc("/cf/{id}", update).Methods(http.MethodPut, http.MethodOptions)        // each request calls PushHandler
 }
@@ -25,7 +40,9 @@ func AddAdminRoutes(admin *mux.Router) {
 func AddApiRoutes(api *mux.Router) {
 
 	api.HandleFunc("/cf/{id}/run", CfRun).Methods(http.MethodGet, http.MethodOptions)          // each request calls PushHandler
+	api.HandleFunc("/cf/{id}/run", CfInvoke).Methods(http.MethodPost)                          // each request calls PushHandler
 	api.HandleFunc("/cf/{id}/run/{rid}", CfRunLog).Methods(http.MethodGet, http.MethodOptions) // each request calls PushHandler
+	api.HandleFunc("/cf/{id}/run/{rid}/logs", CfRunLogs).Methods(http.MethodGet)               // each request calls PushHandler
 }
 
 // list godoc
@@ -72,13 +89,18 @@ func create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := model.Validate(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
 	file, _, err := r.FormFile("dockerarc")
 	if err == nil {
 		uri, err := GetContainerUri(model.Container)
 		err2.DebugErr(err)
 
 		go func() {
-			err := server.BuildDockerImage(file, []string{uri.Vendor + "/" + uri.Image})
+			err := server.BuildDockerImage(file, []string{uri.Vendor + "/" + uri.Image}, nil)
 			err2.DebugErr(err)
 		}()
 	}
@@ -115,8 +137,9 @@ func item(w http.ResponseWriter, r *http.Request) {
 // @Accept       json
 // @Produce      json
 // @Param        id path    string  true  "Fuc id" id
+// @Param        status query    string  false  "Run status: queued, running, succeeded, failed or timed_out" gg
 // @Security bearerAuth
-// @Success      200  {object}   cf.CloudFunctionLog
+// @Success      200  {array}   cf.CloudFunctionLog
 //
 // @Router       /admin/cf/{id}/log [get]
 func logs(w http.ResponseWriter, r *http.Request) {
@@ -131,9 +154,14 @@ func logs(w http.ResponseWriter, r *http.Request) {
 
 	f := m.(CloudFunction)
 
-	l, o, s, or := utils.GetPagination(r)
-	arr := ListCfLog(f.Id, l, o, s, or)
-	total := LogsTotal(f.Id)
+	l, o, or, so := utils.GetPagination(r)
+	if so == "id" {
+		so, or = "run_at", "DESC"
+	}
+
+	status := utils.CleanInputString(r.URL.Query().Get("status"))
+	arr := ListCfLog(f.Id, status, l, o, so, or)
+	total := LogsTotal(f.Id, status)
 	w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count")
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Add("X-Total-Count", strconv.FormatInt(*total, 10))
@@ -144,6 +172,97 @@ func logs(w http.ResponseWriter, r *http.Request) {
 	err2.DebugErr(err)
 }
 
+// stats godoc
+// @Summary      Run stats
+// @Description  Cloud function runs summary: count by status, success rate, duration and output size
+// @Tags         Cloud functions
+// @tags Admin
+// @Accept       json
+// @Produce      json
+// @Param        id path    string  true  "Function id" id
+// @Security bearerAuth
+// @Success      200  {object}   cf.RunStats
+//
+// @Router       /admin/cf/{id}/stats [get]
+func stats(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+	vars := mux.Vars(r)
+	m, err := CloudFunction{}.GetById(vars["id"])
+
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	utils.SendResponse(w, 200, GetRunStats(m.(CloudFunction).Id), nil)
+}
+
+// build godoc
+// @Summary      Build function
+// @Description  Build function image from tar.gz sources with Dockerfile, or with runtime template when sources have no Dockerfile.
+// @Description  Build log streamed in response, function container switched to built image version on success
+// @Tags         Cloud functions
+// @Tags         Admin
+// @Accept       multipart/form-data
+// @Produce      plain
+// @Param        id    path     string  true  "Function id" id
+// @Param        source    formData     file  true  "Sources tar.gz" gg
+// @Param        runtime    formData     string  false  "Runtime template: node, python or go" gg
+// @Security bearerAuth
+// @Success      200
+// @Failure      400
+// @Failure      404
+//
+// @Router       /admin/cf/{id}/build [post]
+func build(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+	vars := mux.Vars(r)
+	m, err := CloudFunction{}.GetById(vars["id"])
+
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxSourceSize)
+	file, _, err := r.FormFile("source")
+	if err != nil {
+		http.Error(w, "source archive required", 400)
+		return
+	}
+	defer func() {
+		err := file.Close()
+		err2.DebugErr(err)
+	}()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(200)
+
+	image, err := m.(CloudFunction).Build(file, r.FormValue("runtime"), flushWriter{w})
+	if err != nil {
+		_, err = io.WriteString(w, "Build failed: "+err.Error()+"\n")
+		err2.DebugErr(err)
+		return
+	}
+
+	_, err = io.WriteString(w, "Build succeeded: "+image+"\n")
+	err2.DebugErr(err)
+}
+
+// flushWriter Send every written chunk to client immediately
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
 // deleteItem godoc
 // @Summary      Delete cloud function
 // @Description  Delete cloud function
@@ -185,21 +304,49 @@ func update(w http.ResponseWriter, r *http.Request) {
 		err2.DebugErr(err)
 
 		go func() {
-			err := server.BuildDockerImage(file, []string{uri.Vendor + "/" + uri.Image})
+			err := server.BuildDockerImage(file, []string{uri.Vendor + "/" + uri.Image}, nil)
 			err2.DebugErr(err)
 		}()
-	} else {
+	} else if !(CloudFunction{Container: r.FormValue("container")}).isBuilt() {
+		// built images exist locally only
 		log.Debug(err)
 		server.PullDockerImage(uri.Vendor + "/" + uri.Image)
 	}
 
-	db.MetaDb.GetConnection().Table("cloud_functions").Where("id = ?", vars["id"]).Updates(
+	maxConcurrency, _ := strconv.Atoi(r.FormValue("max_concurrency"))
+	memoryLimit, _ := strconv.ParseInt(r.FormValue("memory_limit"), 10, 64)
+	cpuLimit, _ := strconv.ParseFloat(r.FormValue("cpu_limit"), 64)
+	timeout, _ := strconv.Atoi(r.FormValue("timeout"))
+
+	limits := CloudFunction{
+		MaxConcurrency: maxConcurrency,
+		MemoryLimit:    memoryLimit,
+		CpuLimit:       cpuLimit,
+		Timeout:        timeout,
+		NetworkMode:    r.FormValue("network_mode"),
+		ReadOnly:       r.FormValue("read_only") == "true",
+		User:           r.FormValue("user"),
+	}
+
+	if err := limits.Validate(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	db.MetaDb.GetConnection().Model(CloudFunction{}).Where("id = ?", vars["id"]).Updates(
 		map[string]interface{}{
-			"title":      r.FormValue("title"),
-			"project_id": projectId,
-			"container":  r.FormValue("container"),
-			"params":     r.FormValue("params"),
-			"env":        r.FormValue("env"),
+			"title":           r.FormValue("title"),
+			"project_id":      projectId,
+			"container":       r.FormValue("container"),
+			"params":          r.FormValue("params"),
+			"env":             r.FormValue("env"),
+			"max_concurrency": limits.MaxConcurrency,
+			"memory_limit":    limits.MemoryLimit,
+			"cpu_limit":       limits.CpuLimit,
+			"timeout":         limits.Timeout,
+			"network_mode":    limits.NetworkMode,
+			"read_only":       limits.ReadOnly,
+			"user":            limits.User,
 		},
 	)
 
@@ -240,9 +387,7 @@ func CfRun(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, _ := uuid.NewUUID()
-
-	go cfu.(CloudFunction).Run(id)
+	id := cfu.(CloudFunction).Start(nil, 0, TriggerApi)
 	m := make(map[string]string)
 	m["id"] = id.String()
 
@@ -252,6 +397,95 @@ func CfRun(w http.ResponseWriter, r *http.Request) {
 	err2.DebugErr(err)
 }
 
+// CfInvoke godoc
+// @Summary      Run function with payload
+// @Description  Run function in dedicated container, JSON payload passed on stdin and in CF_PAYLOAD env variable.
+// @Description  With wait=true blocks until container exit or timeout and returns its output
+// @Tags         Cloud functions
+// @Tags         Public Api
+// @Accept       json
+// @Produce      json
+// @Param        db-key    header     string  false  "Auth key" gg
+// @Param        id    path     string  true  "Function id" gg
+// @Param        wait    query     bool  false  "Wait run result" gg
+// @Param        timeout    query     int  false  "Run timeout in seconds, limited by function timeout" gg
+// @Param        payload    body     object  false  "Function payload" gg
+// @Success      200 {object} cf.RunResult
+// @Failure      400
+// @Failure      404
+// @Failure      500 {object} cf.RunResult
+// @Failure      503
+// @Failure      504 {object} cf.RunResult
+//
+// @Router       /api/cf/{id}/run [post]
+func CfInvoke(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	vars := mux.Vars(r)
+	cfu, err := CloudFunction{}.GetById(vars["id"])
+
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadSize+1))
+	if err != nil || len(payload) > maxPayloadSize {
+		http.Error(w, "Payload too large", 400)
+		return
+	}
+
+	if len(payload) > 0 && !json.Valid(payload) {
+		http.Error(w, "Payload is not valid JSON", 400)
+		return
+	}
+
+	// limited by function timeout on run
+	timeout, _ := strconv.Atoi(r.URL.Query().Get("timeout"))
+
+	if r.URL.Query().Get("wait") != "true" {
+		id := cfu.(CloudFunction).Start(payload, time.Duration(timeout)*time.Second, TriggerApi)
+
+		resp, _ := json.Marshal(map[string]string{"id": id.String()})
+		w.WriteHeader(200)
+		_, err = w.Write(resp)
+		err2.DebugErr(err)
+		return
+	}
+
+	id, _ := uuid.NewUUID()
+	result, err := cfu.(CloudFunction).Invoke(id, payload, time.Duration(timeout)*time.Second, TriggerApi)
+
+	// run timed out or killed on resource limit, output written before kill returned
+	if result.Violation != "" || errors.Is(err, ErrRunTimeout) {
+		status := 500
+		if errors.Is(err, ErrRunTimeout) {
+			status = 504
+		}
+
+		resp, _ := json.Marshal(result)
+		w.WriteHeader(status)
+		_, err = w.Write(resp)
+		err2.DebugErr(err)
+		return
+	}
+
+	if errors.Is(err, ErrQueueTimeout) {
+		http.Error(w, err.Error(), 503)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	resp, _ := json.Marshal(result)
+	w.WriteHeader(200)
+	_, err = w.Write(resp)
+	err2.DebugErr(err)
+}
+
 // CfRunLog
 // @Summary      List logs
 // @Description  List logs of function run
@@ -281,3 +515,109 @@ func CfRunLog(w http.ResponseWriter, r *http.Request) {
 	_, err := w.Write(resp)
 	err2.DebugErr(err)
 }
+
+// CfRunLogs
+// @Summary      Stream run logs
+// @Description  Server sent events with container output of function run: stdout and stderr events with output chunks, end event on run finish.
+// @Description  Output of finished runs sent from run log
+// @Tags         Cloud functions
+// @Tags         Public Api
+// @Produce      text/event-stream
+// @Param        db-key    header     string  false  "Auth key" true
+// @Param        id    path     string  true  "Function id"
+// @Param        rid    path     string  true  "Run id"
+// @Success      200
+// @Failure      404
+//
+// @Router       /api/cf/{id}/run/{rid}/logs [get]
+func CfRunLogs(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	vars := mux.Vars(r)
+	fid, err := uuid.Parse(vars["id"])
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	rid, err := uuid.Parse(vars["rid"])
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	stream := getRunStream(rid, fid)
+
+	var logModel CloudFunctionLog
+	if stream == nil {
+		tx := db.MetaDb.GetConnection().First(&logModel, "id = ? AND function_id = ?", rid, fid)
+		if tx.RowsAffected < 1 {
+			w.WriteHeader(404)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(200)
+
+	sse := flushWriter{w}
+
+	if stream == nil {
+		writeEvent(sse, "stdout", logModel.Result)
+		writeEvent(sse, "stderr", logModel.Stderr)
+		writeEvent(sse, "end", "")
+		return
+	}
+
+	// next chunk position, stream is resubscribed from it after overflow
+	pos := 0
+	for {
+		backlog, ch := stream.subscribe(pos)
+		for _, chunk := range backlog {
+			writeEvent(sse, chunk.Stream, chunk.Data)
+			pos = chunk.Seq + 1
+		}
+
+		if ch == nil {
+			writeEvent(sse, "end", "")
+			return
+		}
+
+	read:
+		for {
+			select {
+			case chunk, ok := <-ch:
+				if !ok {
+					break read
+				}
+				writeEvent(sse, chunk.Stream, chunk.Data)
+				pos = chunk.Seq + 1
+			case <-r.Context().Done():
+				stream.unsubscribe(ch)
+				return
+			}
+		}
+	}
+}
+
+// writeEvent Write server sent event, multiline data split to data lines
+func writeEvent(w io.Writer, event string, data string) {
+	if event != "end" && data == "" {
+		return
+	}
+
+	// line break ending chunk does not start empty data line
+	data = strings.TrimSuffix(data, "\n")
+
+	buf := new(strings.Builder)
+	buf.WriteString("event: " + event + "\n")
+	for _, line := range strings.Split(data, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+
+	_, err := io.WriteString(w, buf.String())
+	err2.DebugErr(err)
+}
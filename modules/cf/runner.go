@@ -0,0 +1,146 @@
+package cf
+
+import (
+	"context"
+	err2 "db-server/err"
+	"db-server/server"
+	"db-server/server/db"
+	"errors"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	// Docker label with function id of run container
+	functionLabel = "db-server.cf.function"
+	// Docker label with run id of run container
+	runLabel = "db-server.cf.run"
+	// Run containers older than max run timeout plus grace are removed by cleanup
+	staleGrace = 5 * time.Minute
+)
+
+// ErrQueueTimeout No free run slot of function in run timeout
+var ErrQueueTimeout = errors.New("function run queue timed out, max concurrency reached")
+
+// limiter Running invocations of one function, waiting callers are woken by released channel
+type limiter struct {
+	sync.Mutex
+	running  int
+	released chan struct{}
+}
+
+// limiters Function limiters, kept when function max concurrency changes so held slots stay counted
+var limiters = struct {
+	sync.Mutex
+	list map[uuid.UUID]*limiter
+}{list: make(map[uuid.UUID]*limiter)}
+
+// acquire Wait free run slot of function up to context deadline, returns slot release func.
+// Functions without max concurrency are not limited
+func (p CloudFunction) acquire(ctx context.Context) (func(), error) {
+	if p.MaxConcurrency <= 0 {
+		return func() {}, nil
+	}
+
+	limiters.Lock()
+	l, ok := limiters.list[p.Id]
+	if !ok {
+		l = &limiter{released: make(chan struct{})}
+		limiters.list[p.Id] = l
+	}
+	limiters.Unlock()
+
+	for {
+		l.Lock()
+		// lowered limit waits until runs above it finished
+		if l.running < p.MaxConcurrency {
+			l.running++
+			l.Unlock()
+			return l.release, nil
+		}
+		released := l.released
+		l.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ErrQueueTimeout
+		}
+	}
+}
+
+// release Free slot and wake waiting callers
+func (l *limiter) release() {
+	l.Lock()
+	defer l.Unlock()
+
+	l.running--
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// runLabels Labels of run container, used to find stale containers
+func (p CloudFunction) runLabels(runId uuid.UUID) map[string]string {
+	return map[string]string{
+		functionLabel: p.Id.String(),
+		runLabel:      runId.String(),
+	}
+}
+
+// InitCleanup Fail runs interrupted by restart, remove stale run containers now and every 5 minutes
+func InitCleanup() {
+	failInterruptedRuns()
+	CleanupContainers()
+
+	c := server.Cron.GetScheduler()
+	_, err := c.AddFunc("@every 5m", CleanupContainers)
+	err2.DebugErr(err)
+}
+
+// CleanupContainers Fail runs not finished in max run time, remove run containers left after server restart
+// or failed removal, and shared containers of previous function versions
+func CleanupContainers() {
+	failStaleRuns()
+
+	cli, err := server.GetDockerCli()
+	if err != nil {
+		err2.DebugErr(err)
+		return
+	}
+
+	ctx := context.Background()
+
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", functionLabel)),
+	})
+	if err != nil {
+		err2.DebugErr(err)
+		return
+	}
+
+	deadline := time.Now().Add(-maxRunTimeout*time.Second - staleGrace)
+	for _, c := range containers {
+		if time.Unix(c.Created, 0).After(deadline) {
+			continue
+		}
+
+		log.Debug("Remove stale cf container " + c.ID)
+		err := cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true})
+		err2.DebugErr(err)
+	}
+
+	var functions []CloudFunction
+	db.MetaDb.GetConnection().Where("container_id <> ''").Find(&functions)
+	for _, f := range functions {
+		log.Debug("Remove shared cf container " + f.ContainerId)
+		err := cli.ContainerRemove(ctx, f.ContainerId, types.ContainerRemoveOptions{Force: true})
+		err2.DebugErr(err)
+
+		db.MetaDb.GetConnection().Model(CloudFunction{}).Where("id = ?", f.Id).Update("container_id", "")
+	}
+}
@@ -0,0 +1,199 @@
+package cf
+
+import (
+	"db-server/server/db"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+	"os"
+	"time"
+)
+
+const (
+	RunQueued    = "queued"
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+	RunTimedOut  = "timed_out"
+)
+
+const (
+	// TriggerApi Run requested with public api
+	TriggerApi = "api"
+	// TriggerCron Run started by cron job
+	TriggerCron = "cron"
+	// TriggerHook Run called by topic event hook
+	TriggerHook = "hook"
+)
+
+// swagger:model
+type RunStats struct {
+	// The function UUID
+	// example: 6204037c-30e6-408b-8aaa-dd8219520b4b
+	FunctionId uuid.UUID `json:"function_id"`
+	// Runs count
+	Total     int64 `json:"total"`
+	Queued    int64 `json:"queued"`
+	Running   int64 `json:"running"`
+	Succeeded int64 `json:"succeeded"`
+	Failed    int64 `json:"failed"`
+	TimedOut  int64 `json:"timed_out"`
+	// Succeeded share of finished runs
+	// example: 0.95
+	SuccessRate float64 `json:"success_rate"`
+	// Average duration of finished runs in milliseconds
+	AvgDuration float64 `json:"avg_duration"`
+	// Max duration of finished runs in milliseconds
+	MaxDuration int64 `json:"max_duration"`
+	// Average output size of finished runs in bytes
+	AvgOutputSize float64 `json:"avg_output_size"`
+	// Last run date time
+	LastRunAt *time.Time `json:"last_run_at"`
+}
+
+// instanceName Host name of server, runs are failed on restart of instance which started them
+var instanceName, _ = os.Hostname()
+
+// queueRun Create run record, existing record of run kept
+func (p CloudFunction) queueRun(runId uuid.UUID, trigger string) {
+	record := CloudFunctionLog{
+		Id:         runId,
+		FunctionId: p.Id,
+		RunAt:      time.Now(),
+		Status:     RunQueued,
+		Trigger:    trigger,
+		Instance:   instanceName,
+	}
+
+	db.MetaDb.GetConnection().Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+}
+
+// startRun Mark run started when its container started
+func (p CloudFunction) startRun(runId uuid.UUID, startedAt time.Time) {
+	db.MetaDb.GetConnection().Model(&CloudFunctionLog{}).Where("id = ?", runId).Updates(
+		map[string]interface{}{
+			"status":     RunRunning,
+			"started_at": startedAt,
+		},
+	)
+}
+
+// finishRun Set run status from result and save result to run record
+func (p CloudFunction) finishRun(runId uuid.UUID, result *RunResult, err error) {
+	result.Status = RunSucceeded
+	if errors.Is(err, ErrRunTimeout) {
+		result.Status = RunTimedOut
+	} else if err != nil || result.ExitCode != 0 {
+		result.Status = RunFailed
+	}
+
+	runErr := ""
+	if err != nil {
+		runErr = err.Error()
+	}
+
+	db.MetaDb.GetConnection().Model(&CloudFunctionLog{}).Where("id = ?", runId).Updates(
+		map[string]interface{}{
+			"status":      result.Status,
+			"finished_at": time.Now(),
+			"duration":    result.Duration,
+			"exit_code":   result.ExitCode,
+			"result":      result.Stdout,
+			"stderr":      result.Stderr,
+			"output_size": result.OutputSize,
+			"error":       runErr,
+			"violation":   result.Violation,
+			"truncated":   result.Truncated,
+		},
+	)
+}
+
+// failInterruptedRuns Runs left queued or running by stopped instance never finish,
+// runs of other instances sharing meta db are not touched
+func failInterruptedRuns() {
+	db.MetaDb.GetConnection().Model(&CloudFunctionLog{}).
+		Where("status IN ? AND instance = ?", []string{RunQueued, RunRunning}, instanceName).
+		Updates(
+			map[string]interface{}{
+				"status":      RunFailed,
+				"finished_at": time.Now(),
+				"error":       "run interrupted by server restart",
+			},
+		)
+}
+
+// failStaleRuns Queue wait and run are limited by max run timeout, older unfinished runs belong to lost instances
+func failStaleRuns() {
+	deadline := time.Now().Add(-maxRunTimeout*time.Second - staleGrace)
+
+	db.MetaDb.GetConnection().Model(&CloudFunctionLog{}).
+		Where("status IN ? AND run_at < ?", []string{RunQueued, RunRunning}, deadline).
+		Updates(
+			map[string]interface{}{
+				"status":      RunFailed,
+				"finished_at": time.Now(),
+				"error":       "run not finished in max run timeout, server stopped",
+			},
+		)
+}
+
+// GetRunStats Summary of function runs by status
+func GetRunStats(fId uuid.UUID) RunStats {
+	stats := RunStats{FunctionId: fId}
+
+	var rows []struct {
+		Status     string
+		Total      int64
+		Duration   int64
+		MaxTime    int64
+		OutputSize int64
+	}
+
+	db.MetaDb.GetConnection().Model(&CloudFunctionLog{}).
+		Select("status, count(*) AS total, sum(duration) AS duration, max(duration) AS max_time, sum(output_size) AS output_size").
+		Where("function_id = ?", fId).
+		Group("status").
+		Scan(&rows)
+
+	var finished, duration, outputSize int64
+	for _, row := range rows {
+		stats.Total += row.Total
+
+		switch row.Status {
+		case RunQueued:
+			stats.Queued = row.Total
+			continue
+		case RunRunning:
+			stats.Running = row.Total
+			continue
+		case RunSucceeded:
+			stats.Succeeded = row.Total
+		case RunFailed:
+			stats.Failed = row.Total
+		case RunTimedOut:
+			stats.TimedOut = row.Total
+		default:
+			// runs logged before run statuses
+			continue
+		}
+
+		finished += row.Total
+		duration += row.Duration
+		outputSize += row.OutputSize
+		stats.MaxDuration = max(stats.MaxDuration, row.MaxTime)
+	}
+
+	if finished > 0 {
+		stats.SuccessRate = float64(stats.Succeeded) / float64(finished)
+		stats.AvgDuration = float64(duration) / float64(finished)
+		stats.AvgOutputSize = float64(outputSize) / float64(finished)
+	}
+
+	var last CloudFunctionLog
+	tx := db.MetaDb.GetConnection().Where("function_id = ?", fId).Order("run_at DESC").First(&last)
+	if tx.RowsAffected > 0 {
+		stats.LastRunAt = &last.RunAt
+	}
+
+	return stats
+}
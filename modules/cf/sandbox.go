@@ -0,0 +1,113 @@
+package cf
+
+import (
+	"errors"
+	"github.com/docker/docker/api/types/container"
+	"strings"
+	"time"
+)
+
+const (
+	// ViolationTimeout Container killed after function timeout
+	ViolationTimeout = "timeout"
+	// ViolationMemory Container killed by out of memory killer
+	ViolationMemory = "memory"
+)
+
+// ErrMemoryLimit Function container killed on memory limit
+var ErrMemoryLimit = errors.New("function memory limit exceeded")
+
+// Docker does not start containers with less memory
+const minMemoryLimit = 6
+
+const (
+	// defaultUser Unprivileged nobody user of function container when user not set
+	defaultUser = "65534:65534"
+	// pidsLimit Max processes of function container, stops fork bombs
+	pidsLimit = 256
+)
+
+// Validate Check function sandbox settings
+func (p CloudFunction) Validate() error {
+	if p.NetworkMode != "" && p.NetworkMode != "none" && p.NetworkMode != "bridge" {
+		return errors.New("network mode must be none or bridge")
+	}
+
+	if isRootUser(p.User) {
+		return errors.New("function container can't run as root")
+	}
+
+	if p.MemoryLimit < 0 || (p.MemoryLimit > 0 && p.MemoryLimit < minMemoryLimit) {
+		return errors.New("memory limit must be at least 6 MB")
+	}
+
+	if p.CpuLimit < 0 {
+		return errors.New("cpu limit must be positive")
+	}
+
+	if p.Timeout < 0 || p.Timeout > maxRunTimeout {
+		return errors.New("timeout must be between 0 and 300 seconds")
+	}
+
+	return nil
+}
+
+// getTimeout Function run timeout, container killed on expiry
+func (p CloudFunction) getTimeout() time.Duration {
+	if p.Timeout <= 0 {
+		return defaultRunTimeout * time.Second
+	}
+	return time.Duration(p.Timeout) * time.Second
+}
+
+// isRootUser Container user is root by name or uid
+func isRootUser(user string) bool {
+	name, _, _ := strings.Cut(strings.TrimSpace(user), ":")
+	return name == "root" || (name != "" && strings.Trim(name, "0") == "")
+}
+
+// runUser Container user, nobody when not set
+func (p CloudFunction) runUser() string {
+	if p.User == "" {
+		return defaultUser
+	}
+	return p.User
+}
+
+// networkMode Container network, no network when not set
+func (p CloudFunction) networkMode() container.NetworkMode {
+	if p.NetworkMode == "" {
+		return "none"
+	}
+	return container.NetworkMode(p.NetworkMode)
+}
+
+// hostConfig Resource limits and isolation of function container
+func (p CloudFunction) hostConfig() *container.HostConfig {
+	pids := int64(pidsLimit)
+
+	config := &container.HostConfig{
+		NetworkMode:    p.networkMode(),
+		ReadonlyRootfs: p.ReadOnly,
+		CapDrop:        []string{"ALL"},
+		SecurityOpt:    []string{"no-new-privileges"},
+		Resources:      container.Resources{PidsLimit: &pids},
+	}
+
+	if p.MemoryLimit > 0 {
+		// same swap limit disables swap usage over memory limit
+		config.Memory = p.MemoryLimit * 1024 * 1024
+		config.MemorySwap = config.Memory
+	}
+
+	if p.CpuLimit > 0 {
+		config.NanoCPUs = int64(p.CpuLimit * 1e9)
+	}
+
+	if p.ReadOnly {
+		// functions still can write temporary files
+		config.Tmpfs = map[string]string{"/tmp": ""}
+	}
+
+	return config
+}
@@ -0,0 +1,199 @@
+package cf
+
+import (
+	"github.com/google/uuid"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Finished run output kept for late subscribers before it read from run log
+	streamRetention = time.Minute
+	// Chunks buffered for subscriber, slow subscriber resubscribes from its position on overflow
+	subscriberBuffer = 256
+	// Output kept for late subscribers and run log, further output is only sent to live subscribers
+	maxOutputSize = 4 << 20
+)
+
+// logChunk Part of container output
+type logChunk struct {
+	// Position of chunk in run output
+	Seq int
+	// stdout or stderr
+	Stream string
+	Data   string
+}
+
+// runStream Output of running function container, copied to live subscribers
+type runStream struct {
+	sync.Mutex
+	functionId  uuid.UUID
+	chunks      []logChunk
+	subscribers map[chan logChunk]bool
+	done        bool
+	// chunks written
+	seq int
+	// bytes written, kept output is limited by maxOutputSize
+	written   int64
+	kept      int64
+	truncated bool
+}
+
+// liveRuns Streams of queued, running and just finished runs
+var liveRuns = struct {
+	sync.Mutex
+	list map[uuid.UUID]*runStream
+}{list: make(map[uuid.UUID]*runStream)}
+
+// openRunStream Register run output stream, returns existing stream of run when registered
+func openRunStream(runId uuid.UUID, functionId uuid.UUID) *runStream {
+	liveRuns.Lock()
+	defer liveRuns.Unlock()
+
+	if s, ok := liveRuns.list[runId]; ok {
+		return s
+	}
+
+	s := &runStream{functionId: functionId, subscribers: make(map[chan logChunk]bool)}
+	liveRuns.list[runId] = s
+
+	return s
+}
+
+// getRunStream Output stream of function run, nil when run unknown or finished long ago
+func getRunStream(runId uuid.UUID, functionId uuid.UUID) *runStream {
+	liveRuns.Lock()
+	defer liveRuns.Unlock()
+
+	s, ok := liveRuns.list[runId]
+	if !ok || s.functionId != functionId {
+		return nil
+	}
+
+	return s
+}
+
+// finish Close subscribers and forget stream after retention
+func (s *runStream) finish(runId uuid.UUID) {
+	s.Lock()
+	s.done = true
+	for ch := range s.subscribers {
+		close(ch)
+	}
+	s.subscribers = nil
+	s.Unlock()
+
+	time.AfterFunc(streamRetention, func() {
+		liveRuns.Lock()
+		delete(liveRuns.list, runId)
+		liveRuns.Unlock()
+	})
+}
+
+// subscribe Kept output from chunk position and channel with further output. Channel closed on run end
+// or subscriber overflow, rest of output is read by next subscribe. Channel is nil when run finished.
+// Output over maxOutputSize is not kept and missed by subscriber which overflowed after it
+func (s *runStream) subscribe(from int) ([]logChunk, chan logChunk) {
+	s.Lock()
+	defer s.Unlock()
+
+	backlog := make([]logChunk, 0)
+	for _, chunk := range s.chunks {
+		if chunk.Seq >= from {
+			backlog = append(backlog, chunk)
+		}
+	}
+
+	if s.done {
+		return backlog, nil
+	}
+
+	ch := make(chan logChunk, subscriberBuffer)
+	s.subscribers[ch] = true
+
+	return backlog, ch
+}
+
+// unsubscribe Stop sending output to disconnected subscriber
+func (s *runStream) unsubscribe(ch chan logChunk) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.subscribers[ch] {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (s *runStream) write(stream string, data string) {
+	s.Lock()
+	defer s.Unlock()
+
+	chunk := logChunk{Seq: s.seq, Stream: stream, Data: data}
+	s.seq++
+	s.written += int64(len(data))
+
+	if free := maxOutputSize - s.kept; int64(len(data)) > free {
+		s.truncated = true
+		// cut on rune start, run log is stored as text
+		for free > 0 && !utf8.RuneStart(data[free]) {
+			free--
+		}
+		if free > 0 {
+			s.chunks = append(s.chunks, logChunk{Seq: chunk.Seq, Stream: stream, Data: data[:free]})
+		}
+		s.kept = maxOutputSize
+	} else {
+		s.chunks = append(s.chunks, chunk)
+		s.kept += int64(len(data))
+	}
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- chunk:
+		default:
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// size Bytes written to stream and whether output was truncated to maxOutputSize
+func (s *runStream) size() (int64, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.written, s.truncated
+}
+
+// output Kept output of stream
+func (s *runStream) output(stream string) string {
+	s.Lock()
+	defer s.Unlock()
+
+	buf := new(strings.Builder)
+	for _, chunk := range s.chunks {
+		if chunk.Stream == stream {
+			buf.WriteString(chunk.Data)
+		}
+	}
+
+	return buf.String()
+}
+
+// writer Writer of stdout or stderr, used to demultiplex docker logs
+func (s *runStream) writer(stream string) streamWriter {
+	return streamWriter{s: s, stream: stream}
+}
+
+type streamWriter struct {
+	s      *runStream
+	stream string
+}
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	w.s.write(w.stream, string(p))
+	return len(p), nil
+}
@@ -2,6 +2,7 @@ package cron
 
 import (
 	"db-server/modules/cf"
+	"db-server/modules/ds"
 	"db-server/server"
 	"db-server/server/db"
 	"db-server/utils"
@@ -69,7 +70,7 @@ func (j CronJob) Schedule(cron *cron.Cron) {
 		function, err := cf.CloudFunction{}.GetById(j.FunctionId.String())
 		if err == nil {
 			id, _ := uuid.NewUUID()
-			function.(cf.CloudFunction).Run(id)
+			function.(cf.CloudFunction).Run(id, cf.TriggerCron)
 		}
 	})
 
@@ -103,6 +104,9 @@ func InitCron() {
 
 		offset += batchSize
 	}
+
+	ds.InitSync()
+	cf.InitCleanup()
 }
 
 func StopCron() {
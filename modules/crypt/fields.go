@@ -0,0 +1,455 @@
+package crypt
+
+import (
+	err2 "db-server/err"
+	"db-server/modules/rdb"
+	"db-server/utils"
+	"encoding/base64"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"strconv"
+	"strings"
+)
+
+// Encrypted value format is enc:<key version>:<base64 of nonce and ciphertext>
+const valuePrefix = "enc:"
+
+// fieldCipher Encrypted fields config and keys of topic
+type fieldCipher struct {
+	paths         []string
+	deterministic map[string]bool
+	keys          []ProjectKey
+}
+
+func splitPaths(raw string) []string {
+	var paths []string
+	for _, path := range strings.Split(raw, ";") {
+		path = strings.TrimSpace(path)
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// newFieldCipher Load topic config and project keys. Returns nil when topic has no encrypted fields
+func newFieldCipher(topic rdb.Rdb, write bool) (*fieldCipher, error) {
+	c := &fieldCipher{deterministic: make(map[string]bool)}
+
+	c.paths = splitPaths(topic.EncryptedFields)
+	for _, path := range splitPaths(topic.DeterministicFields) {
+		c.deterministic[path] = true
+		c.paths = append(c.paths, path)
+	}
+
+	if len(c.paths) == 0 {
+		return nil, nil
+	}
+
+	c.keys = GetProjectKeys(topic.ProjectId)
+	if len(c.keys) == 0 && write {
+		key, err := RotateProjectKey(topic.ProjectId)
+		if err != nil {
+			// key may be created by concurrent request
+			c.keys = GetProjectKeys(topic.ProjectId)
+			if len(c.keys) == 0 {
+				return nil, err
+			}
+		} else {
+			c.keys = []ProjectKey{key}
+		}
+	}
+
+	return c, nil
+}
+
+func (c *fieldCipher) encryptWith(key ProjectKey, value interface{}, deterministic bool) (string, error) {
+	raw, err := bson.Marshal(bson.D{{Key: "v", Value: value}})
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := key.secret()
+	if err != nil {
+		return "", err
+	}
+
+	var data []byte
+	if deterministic {
+		data, err = utils.EncryptDeterministic(secret, raw)
+	} else {
+		data, err = utils.Encrypt(secret, raw)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return valuePrefix + strconv.Itoa(key.Version) + ":" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func (c *fieldCipher) encryptFunc(path string) func(interface{}) (interface{}, error) {
+	return func(value interface{}) (interface{}, error) {
+		return c.encryptWith(c.keys[0], value, c.deterministic[path])
+	}
+}
+
+func (c *fieldCipher) decrypt(value interface{}) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok || !strings.HasPrefix(s, valuePrefix) {
+		return value, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(s, valuePrefix), ":", 2)
+	if len(parts) != 2 {
+		return value, nil
+	}
+
+	version, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return value, nil
+	}
+
+	for _, key := range c.keys {
+		if key.Version != version {
+			continue
+		}
+
+		data, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return value, err
+		}
+
+		secret, err := key.secret()
+		if err != nil {
+			return value, err
+		}
+
+		raw, err := utils.Decrypt(secret, data)
+		if err != nil {
+			return value, err
+		}
+
+		var d bson.D
+		if err := bson.Unmarshal(raw, &d); err != nil || len(d) == 0 {
+			return value, err
+		}
+
+		return d[0].Value, nil
+	}
+
+	return value, errors.New("unknown key version " + parts[0])
+}
+
+// encryptEntry Encrypt configured paths in value of document key, key may be dotted path
+func (c *fieldCipher) encryptEntry(key string, value interface{}) (interface{}, error) {
+	var err error
+	for _, path := range c.paths {
+		switch {
+		case key == path:
+			value, err = transformPath(value, nil, c.encryptFunc(path))
+		case strings.HasPrefix(path, key+"."):
+			value, err = transformPath(value, strings.Split(strings.TrimPrefix(path, key+"."), "."), c.encryptFunc(path))
+		case strings.HasPrefix(key, path+"."):
+			err = errors.New("field " + path + " is encrypted and can't be partially updated")
+		}
+
+		if err != nil {
+			return value, err
+		}
+	}
+
+	return value, nil
+}
+
+func (c *fieldCipher) encryptDocument(doc interface{}) (interface{}, error) {
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(d))
+		for k, v := range d {
+			nv, err := c.encryptEntry(k, v)
+			if err != nil {
+				return doc, err
+			}
+			res[k] = nv
+		}
+		return res, nil
+	case bson.M:
+		res, err := c.encryptDocument(map[string]interface{}(d))
+		if err != nil {
+			return doc, err
+		}
+		return bson.M(res.(map[string]interface{})), nil
+	case bson.D:
+		res := make(bson.D, len(d))
+		for i, e := range d {
+			nv, err := c.encryptEntry(e.Key, e.Value)
+			if err != nil {
+				return doc, err
+			}
+			res[i] = bson.E{Key: e.Key, Value: nv}
+		}
+		return res, nil
+	}
+
+	return doc, nil
+}
+
+// touches Is key one of encrypted paths, its parent or child
+func (c *fieldCipher) touches(key string) (string, bool) {
+	for _, path := range c.paths {
+		if key == path || strings.HasPrefix(path, key+".") || strings.HasPrefix(key, path+".") {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// EncryptDocument Encrypt configured fields of document before write
+func EncryptDocument(topic rdb.Rdb, doc interface{}) (interface{}, error) {
+	c, err := newFieldCipher(topic, true)
+	if c == nil || err != nil {
+		return doc, err
+	}
+
+	return c.encryptDocument(doc)
+}
+
+// EncryptUpdate Encrypt configured fields in mongo update document.
+// Values of $set and $setOnInsert are encrypted, other operators can't change encrypted fields
+func EncryptUpdate(topic rdb.Rdb, update map[string]interface{}) (map[string]interface{}, error) {
+	c, err := newFieldCipher(topic, true)
+	if c == nil || err != nil {
+		return update, err
+	}
+
+	res := make(map[string]interface{}, len(update))
+	for op, value := range update {
+		if !strings.HasPrefix(op, "$") {
+			doc, err := c.encryptDocument(update)
+			if err != nil {
+				return update, err
+			}
+			return doc.(map[string]interface{}), nil
+		}
+
+		switch op {
+		case "$set", "$setOnInsert":
+			value, err = c.encryptDocument(value)
+			if err != nil {
+				return update, err
+			}
+		case "$unset":
+		default:
+			if fields, ok := value.(map[string]interface{}); ok {
+				for key := range fields {
+					if path, ok := c.touches(key); ok {
+						return update, errors.New("field " + path + " is encrypted and can't be used in " + op)
+					}
+				}
+			}
+		}
+
+		res[op] = value
+	}
+
+	return res, nil
+}
+
+// EncryptFilter Replace equality conditions on deterministic fields with encrypted values of all key versions.
+// Conditions on other encrypted fields are rejected
+func EncryptFilter(topic rdb.Rdb, filter interface{}) (interface{}, error) {
+	c, err := newFieldCipher(topic, false)
+	if c == nil || err != nil {
+		return filter, err
+	}
+
+	return c.encryptFilter(filter)
+}
+
+func (c *fieldCipher) encryptFilter(filter interface{}) (interface{}, error) {
+	switch f := filter.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(f))
+		for k, v := range f {
+			nv, err := c.encryptCondition(k, v)
+			if err != nil {
+				return filter, err
+			}
+			res[k] = nv
+		}
+		return res, nil
+	case bson.D:
+		res := make(bson.D, len(f))
+		for i, e := range f {
+			nv, err := c.encryptCondition(e.Key, e.Value)
+			if err != nil {
+				return filter, err
+			}
+			res[i] = bson.E{Key: e.Key, Value: nv}
+		}
+		return res, nil
+	}
+
+	return filter, nil
+}
+
+func (c *fieldCipher) encryptCondition(key string, value interface{}) (interface{}, error) {
+	switch key {
+	case "$and", "$or", "$nor":
+		if items, ok := value.([]interface{}); ok {
+			res := make([]interface{}, len(items))
+			for i, item := range items {
+				nv, err := c.encryptFilter(item)
+				if err != nil {
+					return value, err
+				}
+				res[i] = nv
+			}
+			return res, nil
+		}
+		return value, nil
+	}
+
+	path, ok := c.touches(key)
+	if !ok {
+		return value, nil
+	}
+
+	if key != path || !c.deterministic[path] {
+		return value, errors.New("field " + path + " is encrypted and can't be queried")
+	}
+
+	var values []interface{}
+	if cond, ok := value.(map[string]interface{}); ok {
+		switch {
+		case len(cond) == 1 && cond["$eq"] != nil:
+			values = []interface{}{cond["$eq"]}
+		case len(cond) == 1 && cond["$in"] != nil:
+			values, _ = cond["$in"].([]interface{})
+		default:
+			return value, errors.New("only equality queries allowed on encrypted field " + path)
+		}
+	} else {
+		values = []interface{}{value}
+	}
+
+	encrypted := []interface{}{}
+	for _, v := range values {
+		for _, k := range c.keys {
+			ev, err := c.encryptWith(k, v, true)
+			if err != nil {
+				return value, err
+			}
+			encrypted = append(encrypted, ev)
+		}
+	}
+
+	return map[string]interface{}{"$in": encrypted}, nil
+}
+
+// DecryptDocument Decrypt configured fields of stored document. Values which can't be decrypted are left as is
+func DecryptDocument(topic rdb.Rdb, doc bson.D) bson.D {
+	c, err := newFieldCipher(topic, false)
+	if c == nil || err != nil {
+		return doc
+	}
+
+	return c.decryptDocument(doc)
+}
+
+// DecryptDocuments Decrypt configured fields of documents list
+func DecryptDocuments(topic rdb.Rdb, docs []*bson.D) []*bson.D {
+	c, err := newFieldCipher(topic, false)
+	if c == nil || err != nil {
+		return docs
+	}
+
+	for i, doc := range docs {
+		d := c.decryptDocument(*doc)
+		docs[i] = &d
+	}
+
+	return docs
+}
+
+func (c *fieldCipher) decryptDocument(doc bson.D) bson.D {
+	decrypt := func(value interface{}) (interface{}, error) {
+		res, err := c.decrypt(value)
+		err2.DebugErr(err)
+		return res, nil
+	}
+
+	var res interface{} = doc
+	for _, path := range c.paths {
+		res, _ = transformPath(res, strings.Split(path, "."), decrypt)
+	}
+
+	return res.(bson.D)
+}
+
+// transformPath Apply fn to values at path, arrays on the way are traversed like mongo does
+func transformPath(value interface{}, path []string, fn func(interface{}) (interface{}, error)) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	if len(path) == 0 {
+		return fn(value)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		child, ok := v[path[0]]
+		if !ok {
+			return value, nil
+		}
+		nv, err := transformPath(child, path[1:], fn)
+		if err != nil {
+			return value, err
+		}
+		res := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			res[k] = item
+		}
+		res[path[0]] = nv
+		return res, nil
+	case bson.M:
+		res, err := transformPath(map[string]interface{}(v), path, fn)
+		if err != nil {
+			return value, err
+		}
+		return bson.M(res.(map[string]interface{})), nil
+	case bson.D:
+		res := make(bson.D, len(v))
+		copy(res, v)
+		for i, e := range res {
+			if e.Key != path[0] {
+				continue
+			}
+			nv, err := transformPath(e.Value, path[1:], fn)
+			if err != nil {
+				return value, err
+			}
+			res[i].Value = nv
+		}
+		return res, nil
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			nv, err := transformPath(item, path, fn)
+			if err != nil {
+				return value, err
+			}
+			res[i] = nv
+		}
+		return res, nil
+	case bson.A:
+		res, err := transformPath([]interface{}(v), path, fn)
+		if err != nil {
+			return value, err
+		}
+		return bson.A(res.([]interface{})), nil
+	}
+
+	return value, nil
+}
@@ -0,0 +1,85 @@
+package crypt
+
+import (
+	err2 "db-server/err"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+func AddAdminRoutes(admin *mux.Router) {
+	admin.HandleFunc("/projects/{id}/keys", listKeys).Methods(http.MethodGet, http.MethodOptions)   // each request calls PushHandler
+	admin.HandleFunc("/projects/{id}/keys", rotateKey).Methods(http.MethodPost, http.MethodOptions) // each request calls PushHandler
+}
+
+// listKeys godoc
+// @Summary      List project keys
+// @Description  List project encryption key versions
+// @Tags         Projects
+// @tags Admin
+// @Accept       json
+// @Produce      json
+// @Param        id path    string  true  "Project id"
+// @Security bearerAuth
+// @Success      200  {array}   crypt.ProjectKey
+//
+// @Router       /admin/projects/{id}/keys [get]
+func listKeys(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+	vars := mux.Vars(r)
+
+	projectId, err := uuid.Parse(vars["id"])
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	keys := GetProjectKeys(projectId)
+
+	w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("X-Total-Count", strconv.Itoa(len(keys)))
+
+	resp, _ := json.Marshal(keys)
+	w.WriteHeader(200)
+	_, err = w.Write(resp)
+	err2.DebugErr(err)
+}
+
+// rotateKey godoc
+// @Summary      Rotate project key
+// @Description  Create new project encryption key version. New writes use new key, old versions still decrypt existing data
+// @Tags         Projects
+// @tags Admin
+// @Accept       json
+// @Produce      json
+// @Param        id path    string  true  "Project id"
+// @Security bearerAuth
+// @Success      200  {object}   crypt.ProjectKey
+//
+// @Router       /admin/projects/{id}/keys [post]
+func rotateKey(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+	vars := mux.Vars(r)
+
+	projectId, err := uuid.Parse(vars["id"])
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	key, err := RotateProjectKey(projectId)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	resp, _ := json.Marshal(key)
+	w.WriteHeader(200)
+	_, err = w.Write(resp)
+	err2.DebugErr(err)
+}
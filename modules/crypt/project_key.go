@@ -0,0 +1,113 @@
+package crypt
+
+import (
+	"db-server/server/db"
+	"db-server/utils"
+	"encoding/base64"
+	"errors"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+// swagger:model
+type ProjectKey struct {
+	// The key UUID
+	// example: 6204037c-30e6-408b-8aaa-dd8219860b4d
+	Id uuid.UUID `gorm:"primarykey" json:"id"`
+	// The project UUID
+	// example: 6204037c-30e6-403b-8aaa-dd8219860b4d
+	ProjectId uuid.UUID `gorm:"uniqueIndex:idx_project_key_version" json:"project_id"`
+	// Key version, latest version used for new writes
+	// example: 1
+	Version int `gorm:"uniqueIndex:idx_project_key_version" json:"version"`
+	// Key encrypted with server master key
+	Key       string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName Gorm table name
+func (k ProjectKey) TableName() string {
+	return "project_key"
+}
+
+var secrets = struct {
+	sync.RWMutex
+	list map[uuid.UUID][]byte
+}{list: make(map[uuid.UUID][]byte)}
+
+// GetProjectKeys Project keys, latest version first
+func GetProjectKeys(projectId uuid.UUID) []ProjectKey {
+	var keys []ProjectKey
+
+	conn := db.MetaDb.GetConnection()
+	conn.Order("version DESC").Find(&keys, "project_id = ?", projectId)
+
+	return keys
+}
+
+// RotateProjectKey Create new project key version. Old versions are kept to decrypt existing data
+func RotateProjectKey(projectId uuid.UUID) (ProjectKey, error) {
+	version := 1
+	if keys := GetProjectKeys(projectId); len(keys) > 0 {
+		version = keys[0].Version + 1
+	}
+
+	secret, err := utils.GenerateKey()
+	if err != nil {
+		return ProjectKey{}, err
+	}
+
+	master, err := utils.GetMasterKey()
+	if err != nil {
+		return ProjectKey{}, err
+	}
+
+	wrapped, err := utils.Encrypt(master, secret)
+	if err != nil {
+		return ProjectKey{}, err
+	}
+
+	key := ProjectKey{
+		ProjectId: projectId,
+		Version:   version,
+		Key:       base64.StdEncoding.EncodeToString(wrapped),
+	}
+	key.Id, _ = uuid.NewUUID()
+
+	tx := db.MetaDb.GetConnection().Create(&key)
+
+	return key, tx.Error
+}
+
+// secret Decrypted key value
+func (k ProjectKey) secret() ([]byte, error) {
+	secrets.RLock()
+	secret, ok := secrets.list[k.Id]
+	secrets.RUnlock()
+
+	if ok {
+		return secret, nil
+	}
+
+	master, err := utils.GetMasterKey()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(k.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err = utils.Decrypt(master, wrapped)
+	if err != nil {
+		return nil, errors.New("can't decrypt project key, check MASTER_KEY")
+	}
+
+	secrets.Lock()
+	secrets.list[k.Id] = secret
+	secrets.Unlock()
+
+	return secret, nil
+}
@@ -0,0 +1,62 @@
+package ds
+
+import (
+	"db-server/modules/project"
+	"db-server/modules/user"
+	"db-server/utils"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+type DsAccess string
+
+const (
+	// DsAccessPublic Anyone with endpoint id
+	DsAccessPublic DsAccess = "public"
+	// DsAccessKey Project key in db-key header and allowed Origin, default mode
+	DsAccessKey DsAccess = "key"
+	// DsAccessUser Any user with bearer token
+	DsAccessUser DsAccess = "user"
+	// DsAccessAdmin Admin users only
+	DsAccessAdmin DsAccess = "admin"
+)
+
+// checkEndpointAccess Verify request by endpoint access mode, sends 403 when access denied
+func checkEndpointAccess(w http.ResponseWriter, r *http.Request, e DataSourceEndpoint) bool {
+	switch e.Access {
+	case DsAccessPublic:
+		return true
+
+	case DsAccessUser, DsAccessAdmin:
+		usr, err := user.GetUserFromRequest(r)
+		if err != nil || usr.Id == uuid.Nil {
+			utils.Send403Error(w, "Wrong auth token")
+			return false
+		}
+
+		if e.Access == DsAccessAdmin && !usr.Admin {
+			utils.Send403Error(w, "Method not allowed")
+			return false
+		}
+
+		return true
+
+	case DsAccessKey, "":
+		key := r.Header.Get("db-key")
+		p, err := project.Project{}.GetByKey(key)
+		if err != nil || p.Id != e.DataSource.ProjectId || !utils.ValidateKey(p.Key, key) {
+			utils.Send403Error(w, "db-key not Valid")
+			return false
+		}
+
+		if !p.ValidateOrigin(r.Header.Get("Origin")) {
+			utils.Send403Error(w, "Cors error. Origin not allowed")
+			return false
+		}
+
+		return true
+	}
+
+	utils.Send403Error(w, "Unknown access mode")
+	return false
+}
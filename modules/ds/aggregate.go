@@ -0,0 +1,232 @@
+package ds
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// aggregateFunctions Sql functions available in _agg param
+var aggregateFunctions = map[string]string{"count": "COUNT", "sum": "SUM", "avg": "AVG", "min": "MIN", "max": "MAX"}
+
+// RowsAggregate Aggregate function over column, count without column counts rows
+type RowsAggregate struct {
+	Func   string
+	Column string
+	Alias  string
+}
+
+// isAggregated Query groups rows or computes aggregates
+func (q RowsQuery) isAggregated() bool {
+	return len(q.GroupBy) > 0 || len(q.Aggregates) > 0
+}
+
+// parseAggregation Read _group=column,column and _agg=count,sum:column params.
+// Columns are output names of whitelisted columns, functions other than count can't be used on masked columns
+func (q *RowsQuery) parseAggregation(values url.Values, columns map[string]bool, project projection) error {
+	for _, name := range splitList(values.Get("_group")) {
+		if !columns[name] {
+			return errors.New("grouping by " + name + " is not allowed")
+		}
+		q.GroupBy = append(q.GroupBy, project.sourceColumn(name))
+	}
+
+	for _, item := range splitList(values.Get("_agg")) {
+		fn, name, _ := strings.Cut(item, ":")
+		fn = strings.ToLower(strings.TrimSpace(fn))
+		name = strings.TrimSpace(name)
+
+		if _, ok := aggregateFunctions[fn]; !ok {
+			return errors.New("unknown aggregate function " + fn)
+		}
+
+		agg := RowsAggregate{Func: fn, Alias: fn}
+		if name != "" {
+			if !columns[name] {
+				return errors.New("aggregating " + name + " is not allowed")
+			}
+
+			agg.Column = project.sourceColumn(name)
+			agg.Alias = fn + "_" + name
+
+			if fn != "count" && project.masks[agg.Column] != "" {
+				return errors.New("column " + name + " is masked, only count is allowed")
+			}
+		} else if fn != "count" {
+			return errors.New("aggregate function " + fn + " requires column")
+		}
+
+		q.Aggregates = append(q.Aggregates, agg)
+	}
+
+	return nil
+}
+
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// aggregated Apply group by and aggregate select to sql query, columns and aliases are quoted by gorm
+func (r sqlReader) aggregated(tx *gorm.DB, query RowsQuery) *gorm.DB {
+	var parts []string
+	var vars []interface{}
+
+	for _, column := range query.GroupBy {
+		parts = append(parts, "?")
+		vars = append(vars, clause.Column{Name: column})
+	}
+
+	for _, agg := range query.Aggregates {
+		if agg.Column == "" {
+			parts = append(parts, aggregateFunctions[agg.Func]+"(*) AS ?")
+			vars = append(vars, clause.Column{Name: agg.Alias})
+		} else {
+			parts = append(parts, aggregateFunctions[agg.Func]+"(?) AS ?")
+			vars = append(vars, clause.Column{Name: agg.Column}, clause.Column{Name: agg.Alias})
+		}
+	}
+
+	tx = tx.Select(strings.Join(parts, ", "), vars...)
+
+	if len(query.GroupBy) > 0 {
+		groupBy := clause.GroupBy{}
+		for _, column := range query.GroupBy {
+			groupBy.Columns = append(groupBy.Columns, clause.Column{Name: column})
+		}
+		tx = tx.Clauses(groupBy)
+	}
+
+	return tx
+}
+
+// aggregateRows Group and aggregate rows loaded in memory
+func aggregateRows(rows []map[string]interface{}, query RowsQuery) []map[string]interface{} {
+	type group struct {
+		row    map[string]interface{}
+		values map[string][]interface{}
+		count  int64
+	}
+
+	var keys []string
+	groups := make(map[string]*group)
+
+	var aggColumns []string
+	seen := make(map[string]bool)
+	for _, agg := range query.Aggregates {
+		if agg.Column != "" && !seen[agg.Column] {
+			seen[agg.Column] = true
+			aggColumns = append(aggColumns, agg.Column)
+		}
+	}
+
+	for _, row := range rows {
+		var key strings.Builder
+		for _, column := range query.GroupBy {
+			key.WriteString(valueString(row[column]) + "\x00")
+		}
+
+		g, ok := groups[key.String()]
+		if !ok {
+			g = &group{row: make(map[string]interface{}), values: make(map[string][]interface{})}
+			for _, column := range query.GroupBy {
+				g.row[column] = row[column]
+			}
+			groups[key.String()] = g
+			keys = append(keys, key.String())
+		}
+
+		g.count++
+		for _, column := range aggColumns {
+			if row[column] != nil {
+				g.values[column] = append(g.values[column], row[column])
+			}
+		}
+	}
+
+	// sql returns one row of aggregates for empty table
+	if len(query.GroupBy) == 0 && len(keys) == 0 {
+		groups[""] = &group{row: make(map[string]interface{}), values: make(map[string][]interface{})}
+		keys = append(keys, "")
+	}
+
+	sort.Strings(keys)
+
+	res := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		g := groups[key]
+		for _, agg := range query.Aggregates {
+			g.row[agg.Alias] = aggregateValues(agg, g.values[agg.Column], g.count)
+		}
+		res = append(res, g.row)
+	}
+
+	return res
+}
+
+func aggregateValues(agg RowsAggregate, values []interface{}, count int64) interface{} {
+	switch agg.Func {
+	case "count":
+		if agg.Column == "" {
+			return count
+		}
+		return int64(len(values))
+	case "min", "max":
+		var res interface{}
+		for _, value := range values {
+			cmp := compareValues(value, res)
+			if res == nil || (agg.Func == "min" && cmp < 0) || (agg.Func == "max" && cmp > 0) {
+				res = value
+			}
+		}
+		return res
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	var sum float64
+	for _, value := range values {
+		f, err := strconv.ParseFloat(valueString(value), 64)
+		if err == nil {
+			sum += f
+		}
+	}
+
+	if agg.Func == "avg" {
+		return sum / float64(len(values))
+	}
+
+	return sum
+}
+
+// applyAggregated Rename group columns to output names and mask their values
+func (p projection) applyAggregated(rows []map[string]interface{}, query RowsQuery) []map[string]interface{} {
+	res := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		item := make(map[string]interface{}, len(row))
+		for _, column := range query.GroupBy {
+			name := column
+			if alias, ok := p.aliases[column]; ok {
+				name = alias
+			}
+			item[name] = maskValue(normalizeValue(row[column]), p.masks[column])
+		}
+		for _, agg := range query.Aggregates {
+			item[agg.Alias] = normalizeValue(row[agg.Alias])
+		}
+		res[i] = item
+	}
+	return res
+}
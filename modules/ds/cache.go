@@ -0,0 +1,153 @@
+package ds
+
+import (
+	"container/list"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCacheTtl Cache ttl in seconds of data sources without configured ttl
+	defaultCacheTtl = 60
+	// cacheSize Max cached results of all data sources
+	cacheSize = 1000
+)
+
+// cachedResult Rows page and total count of endpoint query
+type cachedResult struct {
+	key      string
+	sourceId string
+	rows     []map[string]interface{}
+	total    int64
+	expireAt time.Time
+}
+
+// resultsCache In-memory LRU of endpoint query results
+type resultsCache struct {
+	sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+var cache = &resultsCache{size: cacheSize, order: list.New(), items: make(map[string]*list.Element)}
+
+func (c *resultsCache) get(key string) (*cachedResult, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	res := el.Value.(*cachedResult)
+	if time.Now().After(res.expireAt) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return res, true
+}
+
+func (c *resultsCache) set(res *cachedResult) {
+	c.Lock()
+	defer c.Unlock()
+
+	if el, ok := c.items[res.key]; ok {
+		el.Value = res
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[res.key] = c.order.PushFront(res)
+
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(*cachedResult).key)
+	}
+}
+
+// purge Remove cached results of data source, all results when source id is empty
+func (c *resultsCache) purge(sourceId string) int {
+	c.Lock()
+	defer c.Unlock()
+
+	cnt := 0
+	for key, el := range c.items {
+		if sourceId == "" || el.Value.(*cachedResult).sourceId == sourceId {
+			c.order.Remove(el)
+			delete(c.items, key)
+			cnt++
+		}
+	}
+
+	return cnt
+}
+
+// PurgeCache Remove cached results of data source endpoints
+func PurgeCache(sourceId string) int {
+	return cache.purge(sourceId)
+}
+
+// cacheKey Endpoint id and normalized query params
+func (e DataSourceEndpoint) cacheKey(query RowsQuery) string {
+	var key strings.Builder
+	key.WriteString(e.Id.String())
+	key.WriteString("|" + strconv.Itoa(query.Limit) + "|" + strconv.Itoa(query.Offset) + "|" + query.Sort + "|" + query.Order)
+	for _, f := range query.Filters {
+		key.WriteString("|" + f.Column + ":" + f.Operator + "=" + f.Value)
+	}
+
+	params := make([]string, 0, len(query.Params))
+	for name, value := range query.Params {
+		params = append(params, name+"="+valueString(value))
+	}
+	sort.Strings(params)
+	key.WriteString("|" + strings.Join(params, "&"))
+
+	key.WriteString("|" + strings.Join(query.GroupBy, ","))
+	for _, agg := range query.Aggregates {
+		key.WriteString("|" + agg.Alias)
+	}
+	return key.String()
+}
+
+// CachedRows Rows and total count of query, served from cache when data source has cache enabled
+func (e DataSourceEndpoint) CachedRows(query RowsQuery) ([]map[string]interface{}, int64, bool, error) {
+	key := e.cacheKey(query)
+
+	if e.DataSource.Cache {
+		if res, ok := cache.get(key); ok {
+			return res.rows, res.total, true, nil
+		}
+	}
+
+	rows, total, err := e.page(query)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	if e.DataSource.Cache {
+		ttl := e.DataSource.CacheTtl
+		if ttl <= 0 {
+			ttl = defaultCacheTtl
+		}
+
+		cache.set(&cachedResult{
+			key:      key,
+			sourceId: e.DataSource.Id.String(),
+			rows:     rows,
+			total:    total,
+			expireAt: time.Now().Add(time.Duration(ttl) * time.Second),
+		})
+	}
+
+	return rows, total, false, nil
+}
@@ -0,0 +1,239 @@
+package ds
+
+import (
+	"context"
+	"crypto/sha256"
+	err2 "db-server/err"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+// Evicted pool closed after grace and its last running query
+const (
+	drainGrace    = time.Minute
+	drainInterval = 5 * time.Second
+	drainMax      = 10 * time.Minute
+)
+
+// connectionManager Opened sql connections of data sources, keyed by source id and version
+type connectionManager struct {
+	sync.Mutex
+	list map[string]*pooledConnection
+}
+
+// pooledConnection Connection of one data source version, ready closed when dial finished
+type pooledConnection struct {
+	sourceId  string
+	updatedAt time.Time
+	ready     chan struct{}
+	conn      *gorm.DB
+	err       error
+}
+
+var connections = &connectionManager{list: make(map[string]*pooledConnection)}
+
+// connectionKey Source id with hash of connection settings, so source loaded before update never gets new pool and vice versa
+func connectionKey(source DataSource) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%d|%d|%d",
+		source.Type, source.Dsn, source.MaxOpenConns, source.MaxIdleConns, source.ConnMaxLifetime, source.UpdatedAt.UnixNano())))
+	return source.Id.String() + ":" + hex.EncodeToString(hash[:8])
+}
+
+// get Connection of data source. Dial runs without manager lock, concurrent requests of same source wait one dial
+func (m *connectionManager) get(source DataSource) (*gorm.DB, error) {
+	key := connectionKey(source)
+
+	m.Lock()
+	pooled, ok := m.list[key]
+	if !ok {
+		pooled = &pooledConnection{sourceId: source.Id.String(), updatedAt: source.UpdatedAt, ready: make(chan struct{})}
+		m.list[key] = pooled
+	}
+	m.Unlock()
+
+	if ok {
+		<-pooled.ready
+		return pooled.conn, pooled.err
+	}
+
+	pooled.conn, pooled.err = openConnection(source)
+	close(pooled.ready)
+
+	m.Lock()
+	if pooled.err != nil {
+		// next request dials again
+		delete(m.list, key)
+	} else {
+		// pools of previous versions are left by requests started before update
+		for k, other := range m.list {
+			if k != key && other.sourceId == pooled.sourceId && other.updatedAt.Before(pooled.updatedAt) {
+				delete(m.list, k)
+				go other.drain()
+			}
+		}
+	}
+	m.Unlock()
+
+	return pooled.conn, pooled.err
+}
+
+// evict Forget connections of data source and close them after running queries
+func (m *connectionManager) evict(sourceId string) {
+	var evicted []*pooledConnection
+
+	m.Lock()
+	for key, pooled := range m.list {
+		if pooled.sourceId == sourceId {
+			delete(m.list, key)
+			evicted = append(evicted, pooled)
+		}
+	}
+	m.Unlock()
+
+	for _, pooled := range evicted {
+		go pooled.drain()
+	}
+}
+
+// drain Close pool when requests which got it before eviction finished, not later than drainMax
+func (c *pooledConnection) drain() {
+	<-c.ready
+	if c.err != nil {
+		return
+	}
+
+	sqlDb, err := c.conn.DB()
+	if err != nil {
+		err2.DebugErr(err)
+		return
+	}
+
+	time.Sleep(drainGrace)
+	for waited := drainGrace; waited < drainMax && sqlDb.Stats().InUse > 0; waited += drainInterval {
+		time.Sleep(drainInterval)
+	}
+
+	err2.DebugErr(sqlDb.Close())
+}
+
+// EvictConnection Close connection of changed or deleted data source
+func EvictConnection(sourceId string) {
+	connections.evict(sourceId)
+}
+
+// openConnection Open sql connection with data source pool limits
+func openConnection(source DataSource) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+
+	dsn, err := source.dsn()
+	if err != nil {
+		return nil, err
+	}
+
+	switch source.Type {
+	case DSTypeMysql:
+		dialector = mysql.Open(dsn)
+	case DSTypePostgres:
+		dialector = postgres.Open(dsn)
+	case DSTypeSqlite:
+		dialector = sqlite.Open(dsn)
+	default:
+		return nil, errors.New("data source " + string(source.Type) + " has no sql connection")
+	}
+
+	conn, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDb, err := conn.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDb.SetMaxOpenConns(source.MaxOpenConns)
+	if source.MaxIdleConns > 0 {
+		sqlDb.SetMaxIdleConns(source.MaxIdleConns)
+	}
+	sqlDb.SetConnMaxLifetime(time.Duration(source.ConnMaxLifetime) * time.Second)
+
+	return conn, nil
+}
+
+// swagger:model
+type ConnectionTest struct {
+	// Connection is working
+	Ok bool `json:"ok"`
+	// Connect and ping time in milliseconds
+	// example: 12
+	Latency int64 `json:"latency"`
+	// Connection error
+	Error string `json:"error,omitempty"`
+}
+
+// TestConnection Open new connection to data source and check it responds
+func (p DataSource) TestConnection(timeout time.Duration) ConnectionTest {
+	start := time.Now()
+	err := p.ping(timeout)
+
+	res := ConnectionTest{Ok: err == nil, Latency: time.Since(start).Milliseconds()}
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+func (p DataSource) ping(timeout time.Duration) error {
+	switch p.Type {
+	case DSTypeMysql, DSTypePostgres, DSTypeSqlite:
+		conn, err := openConnection(p)
+		if err != nil {
+			return err
+		}
+
+		sqlDb, err := conn.DB()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err := sqlDb.Close()
+			err2.DebugErr(err)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		return sqlDb.PingContext(ctx)
+
+	case DSTypeHTTP:
+		resp, err := p.request("", nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 500 {
+			err = errors.New("upstream responded " + resp.Status)
+		}
+		err2.DebugErr(resp.Body.Close())
+		return err
+	}
+
+	dsn, err := p.dsn()
+	if err != nil {
+		return err
+	}
+
+	file, err := openSourceFile(dsn)
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
@@ -6,9 +6,6 @@ import (
 	"db-server/server/db"
 	"errors"
 	"github.com/google/uuid"
-	"gorm.io/driver/mysql"
-	"gorm.io/driver/postgres"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"time"
 )
@@ -20,7 +17,11 @@ const (
 	DSTypePostgres DsType = "Postgres"
 	DSTypeSqlite   DsType = "Sqlite"
 
-	DSTypeXML DsType = "Xml"
+	DSTypeXML    DsType = "Xml"
+	DSTypeCSV    DsType = "Csv"
+	DSTypeJSON   DsType = "Json"
+	DSTypeNDJSON DsType = "Ndjson"
+	DSTypeHTTP   DsType = "Http"
 )
 
 type DataSource struct {
@@ -33,13 +34,32 @@ type DataSource struct {
 	Type DsType `json:"type"`
 	// Data source title
 	Title string `json:"title"`
-	// Data source dsn
+	// Data source dsn, base url for http sources. Stored encrypted with server master key,
+	// password is masked in responses, send masked password to keep stored one
+	// example: user:***@tcp(localhost:3306)/db
 	Dsn string `json:"dsn"`
+	// Auth headers of http sources. Values are masked in responses, send masked value to keep stored one
+	// example: {"Authorization": "Bearer token"}
+	Headers map[string]string `gorm:"-" json:"headers,omitempty"`
+	// Auth headers encrypted with server master key
+	AuthHeaders string `json:"-"`
 	// Linked project  UUID
 	// example: 6204011c-30e6-408b-8aaa-dd8214860b4b
 	ProjectId uuid.UUID `json:"project_id"`
-	// Cache result in local db
+	// Max open connections to sql data source, unlimited when 0
+	// example: 10
+	MaxOpenConns int `json:"max_open_conns"`
+	// Max idle connections to sql data source, 2 by default
+	// example: 2
+	MaxIdleConns int `json:"max_idle_conns"`
+	// Max lifetime of sql connection in seconds, unlimited when 0
+	// example: 300
+	ConnMaxLifetime int `json:"conn_max_lifetime"`
+	// Cache endpoints results in memory
 	Cache bool `json:"cache"`
+	// Cache ttl in seconds, 60 by default
+	// example: 300
+	CacheTtl int `json:"cache_ttl"`
 	// Linked project
 	Project   project.Project
 	CreatedAt time.Time      `json:"-"`
@@ -61,6 +81,8 @@ func (p DataSource) List(limit int, offset int, sort string, order string, filte
 
 	y := make([]interface{}, len(sources))
 	for i, v := range sources {
+		v.maskHeaders()
+		v.maskDsn()
 		y[i] = v
 	}
 
@@ -82,6 +104,9 @@ func (p DataSource) GetById(id string) (interface{}, error) {
 		return source, errors.New("no found")
 	}
 
+	source.maskHeaders()
+	source.maskDsn()
+
 	return source, nil
 }
 
@@ -96,13 +121,63 @@ type DataSourceEndpoint struct {
 	Id uuid.UUID `gorm:"primarykey" json:"id"`
 	// Data source endpoint title
 	Title string `json:"title"`
-	// Endpoint table name
+	// Endpoint table name, request path for http sources
 	SourceTable string `json:"table_name"`
+	// XPath-like row selector for xml sources, dotted path to rows array for json and http sources
+	// example: /catalog/book
+	RowSelector string `json:"row_selector"`
+	// Csv columns delimiter, comma by default
+	// example: ;
+	Delimiter string `json:"delimiter"`
+	// Csv file has no header row, column names are taken from columns
+	NoHeader bool `json:"no_header"`
+	// Columns typing of file sources, list of name:type separated by ";".
+	// Types are string, int, float, bool, date
+	// example: id:int;price:float;title:string
+	Columns string `json:"columns"`
+	// Source columns allowed in public filters and sorting, separated by ";"
+	// example: id;title;created_at
+	FilterColumns string `json:"filter_columns"`
+	// Upstream pagination of http sources: offset, page or empty to load all rows
+	// example: offset
+	Pagination string `json:"pagination"`
+	// Upstream params names of offset and limit or page and page size, separated by comma
+	// example: skip,take
+	PaginationParams string `json:"pagination_params"`
+	// Dotted path to total count in http source response, X-Total-Count header is used when empty
+	// example: meta.total
+	TotalPath string `json:"total_path"`
+	// Visible columns with optional output name, separated by ";". All columns are visible when empty
+	// example: id;email:contact;phone
+	VisibleColumns string `json:"visible_columns"`
+	// Masks of columns values, one of email, phone, partial, hash (keyed with MASTER_KEY), null. Masked columns can't be filtered or sorted
+	// example: email:email;phone:phone;token:null
+	Masks string `json:"masks"`
+	// Read only sql statement used instead of table, named params are written as @name
+	// example: SELECT u.id, count(o.id) AS orders FROM users u JOIN orders o ON o.user_id = u.id WHERE o.created_at > @since GROUP BY u.id
+	Query string `json:"query"`
+	// Typed query params bound from query string, list of name:type separated by ";"
+	// example: since:date
+	QueryParams string `json:"query_params"`
+	// Max rows returned by sql query, 1000 by default
+	// example: 500
+	MaxRows int `json:"max_rows"`
+	// Sql query timeout in seconds, 10 by default
+	// example: 10
+	Timeout int `json:"timeout"`
+	// Access mode: public, key (project key and origin), user (bearer token) or admin. Key by default
+	// example: key
+	Access DsAccess `json:"access"`
+	// Allow create, update and delete of sql table rows
+	Writable bool `json:"writable"`
+	// Columns allowed in writes, separated by ";"
+	// example: title;price
+	WritableColumns string `json:"writable_columns"`
 	// Linked data source UUID
 	// example: 6204011c-33e6-408b-8aaa-dd8214860b4b
 	DataSourceId uuid.UUID `json:"data_source"`
-	// Linked data source
-	DataSource DataSource
+	// Linked data source, preloaded for readers and never sent with its dsn
+	DataSource DataSource     `json:"-"`
 	CreatedAt  time.Time      `json:"-"`
 	UpdatedAt  time.Time      `json:"-"`
 	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
@@ -126,39 +201,8 @@ func (e DataSourceEndpoint) List(limit int, offset int, sort string, order strin
 	return y, nil
 }
 
-var dsConnections = make(map[string]*gorm.DB)
-
 func (e DataSourceEndpoint) getConnection() (*gorm.DB, error) {
-	if conn, ok := dsConnections[e.DataSource.Id.String()]; ok {
-		return conn, nil
-	}
-
-	switch e.DataSource.Type {
-	case DSTypeMysql:
-		conn, err := gorm.Open(mysql.Open(e.DataSource.Dsn), &gorm.Config{})
-		return e.attachConnectionToPool(conn, err)
-
-	case DSTypePostgres:
-		conn, err := gorm.Open(postgres.Open(e.DataSource.Dsn), &gorm.Config{})
-		return e.attachConnectionToPool(conn, err)
-
-	case DSTypeSqlite:
-		conn, err := gorm.Open(sqlite.Open(e.DataSource.Dsn), &gorm.Config{})
-		return e.attachConnectionToPool(conn, err)
-
-	case DSTypeXML:
-		return nil, nil
-	}
-
-	return nil, nil
-}
-
-func (e DataSourceEndpoint) attachConnectionToPool(conn *gorm.DB, err error) (*gorm.DB, error) {
-	if err != nil {
-		return nil, err
-	}
-	dsConnections[e.DataSource.Id.String()] = conn
-	return conn, nil
+	return connections.get(e.DataSource)
 }
 
 func (e DataSourceEndpoint) GetById(id string) (interface{}, error) {
@@ -166,7 +210,7 @@ func (e DataSourceEndpoint) GetById(id string) (interface{}, error) {
 
 	conn := db.MetaDb.GetConnection()
 
-	tx := conn.First(&source, "id = ?", id)
+	tx := conn.Preload("DataSource").First(&source, "id = ?", id)
 
 	if tx.RowsAffected < 1 {
 		return source, errors.New("no found")
@@ -176,17 +220,16 @@ func (e DataSourceEndpoint) GetById(id string) (interface{}, error) {
 }
 
 func (e DataSourceEndpoint) Total() *int64 {
-
-	conn, err := e.getConnection()
-
 	var cnt int64 = 0
 
+	reader, err := e.getReader()
 	if err != nil {
 		err2.DebugErr(err)
 		return &cnt
 	}
 
-	conn.Table(e.SourceTable).Count(&cnt)
+	cnt, err = reader.Count(RowsQuery{})
+	err2.DebugErr(err)
 
 	return &cnt
 }
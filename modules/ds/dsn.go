@@ -0,0 +1,204 @@
+package ds
+
+import (
+	"db-server/server/db"
+	"db-server/utils"
+	"encoding/base64"
+	"errors"
+	"gorm.io/gorm"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Encrypted dsn format is enc:<base64 of nonce and ciphertext>, dsn without prefix is stored before encryption
+const dsnPrefix = "enc:"
+
+// maskedPassword Password in api responses, sending it back keeps stored password
+const maskedPassword = "***"
+
+var (
+	// host=localhost password=secret
+	dsnKeyPasswordRe = regexp.MustCompile(`(?i)(\bpassword=)('(?:[^'\\]|\\.)*'|\S*)`)
+)
+
+// dsn Decrypted data source dsn
+func (p DataSource) dsn() (string, error) {
+	if !strings.HasPrefix(p.Dsn, dsnPrefix) {
+		return p.Dsn, nil
+	}
+
+	master, err := utils.GetMasterKey()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(p.Dsn, dsnPrefix))
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := utils.Decrypt(master, data)
+	if err != nil {
+		return "", errors.New("can't decrypt dsn, check MASTER_KEY")
+	}
+
+	return string(raw), nil
+}
+
+// sealDsn Encrypt dsn from request. Masked password or empty dsn keep stored values
+func (p *DataSource) sealDsn(exist *DataSource) error {
+	plain := p.Dsn
+
+	if exist != nil {
+		stored, err := exist.dsn()
+		if err != nil {
+			return err
+		}
+
+		if plain == "" {
+			plain = stored
+		} else if password, ok := dsnPassword(stored); ok {
+			plain = restorePassword(plain, password)
+		}
+	}
+
+	master, err := utils.GetMasterKey()
+	if err != nil {
+		return err
+	}
+
+	data, err := utils.Encrypt(master, []byte(plain))
+	if err != nil {
+		return err
+	}
+
+	p.Dsn = dsnPrefix + base64.StdEncoding.EncodeToString(data)
+
+	return nil
+}
+
+// SealStoredDsns Encrypt plain dsn of data sources saved before dsn encryption
+func SealStoredDsns(conn *gorm.DB) error {
+	var sources []DataSource
+	conn.Where("dsn <> '' AND dsn NOT LIKE ?", dsnPrefix+"%").Find(&sources)
+
+	for _, source := range sources {
+		plain := source.Dsn
+		if err := source.sealDsn(nil); err != nil {
+			return err
+		}
+
+		// dsn changed meanwhile is sealed by update
+		conn.Model(&DataSource{}).Where("id = ? AND dsn = ?", source.Id, plain).UpdateColumn("dsn", source.Dsn)
+	}
+
+	return nil
+}
+
+// maskDsn Replace stored dsn with decrypted one with redacted password
+func (p *DataSource) maskDsn() {
+	plain, err := p.dsn()
+	if err != nil {
+		p.Dsn = ""
+		return
+	}
+
+	p.Dsn = redactDsn(plain)
+}
+
+// dsnPassword Find password in url, mysql or key-value dsn
+func dsnPassword(dsn string) (string, bool) {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil && u.Host != "" {
+		return u.User.Password()
+	}
+
+	if m := dsnKeyPasswordRe.FindStringSubmatch(dsn); m != nil {
+		return m[2], true
+	}
+
+	if start, end, ok := mysqlPasswordBounds(dsn); ok {
+		return dsn[start:end], true
+	}
+
+	return "", false
+}
+
+// restorePassword Put stored password in place of masked password component of dsn,
+// mask in other parts of dsn is kept
+func restorePassword(dsn string, password string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil && u.Host != "" {
+		if masked, ok := u.User.Password(); ok && masked == maskedPassword {
+			u.User = url.UserPassword(u.User.Username(), password)
+			return u.String()
+		}
+		return dsn
+	}
+
+	if m := dsnKeyPasswordRe.FindStringSubmatchIndex(dsn); m != nil {
+		if dsn[m[4]:m[5]] == maskedPassword {
+			return dsn[:m[4]] + password + dsn[m[5]:]
+		}
+		return dsn
+	}
+
+	if start, end, ok := mysqlPasswordBounds(dsn); ok && dsn[start:end] == maskedPassword {
+		return dsn[:start] + password + dsn[end:]
+	}
+
+	return dsn
+}
+
+// redactDsn Replace password in dsn with mask
+func redactDsn(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil && u.Host != "" {
+		if _, ok := u.User.Password(); ok {
+			return strings.Replace(u.Redacted(), ":xxxxx@", ":"+maskedPassword+"@", 1)
+		}
+		return dsn
+	}
+
+	if dsnKeyPasswordRe.MatchString(dsn) {
+		return dsnKeyPasswordRe.ReplaceAllString(dsn, "${1}"+maskedPassword)
+	}
+
+	if start, end, ok := mysqlPasswordBounds(dsn); ok {
+		return dsn[:start] + maskedPassword + dsn[end:]
+	}
+
+	return dsn
+}
+
+// mysqlPasswordBounds Password position in user:password@tcp(host)/db dsn,
+// password may contain @, address starts after last @ before database name
+func mysqlPasswordBounds(dsn string) (int, int, bool) {
+	slash := strings.LastIndex(dsn, "/")
+	if slash < 0 {
+		return 0, 0, false
+	}
+
+	at := strings.LastIndex(dsn[:slash], "@")
+	if at < 0 {
+		return 0, 0, false
+	}
+
+	colon := strings.Index(dsn[:at], ":")
+	if colon < 0 {
+		return 0, 0, false
+	}
+
+	return colon + 1, at, true
+}
+
+// getSource Data source with stored dsn for connections
+func getSource(id string) (DataSource, error) {
+	var source DataSource
+
+	tx := db.MetaDb.GetConnection().First(&source, "id = ?", id)
+
+	if tx.RowsAffected < 1 {
+		return source, errors.New("no found")
+	}
+
+	return source, nil
+}
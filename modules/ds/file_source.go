@@ -0,0 +1,318 @@
+package ds
+
+import (
+	err2 "db-server/err"
+	"db-server/server"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const s3Prefix = "s3://"
+
+// sourceFileClient Client of files loaded by url, large files may take long to download
+var sourceFileClient = &http.Client{Timeout: 2 * time.Minute}
+
+// openSourceFile Open data source file by local path, http(s) url or s3://path in storage bucket
+func openSourceFile(dsn string) (io.ReadCloser, error) {
+	if strings.HasPrefix(dsn, "http://") || strings.HasPrefix(dsn, "https://") {
+		resp, err := sourceFileClient.Get(dsn)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			_ = resp.Body.Close()
+			return nil, errors.New("can't load " + dsn + ": " + resp.Status)
+		}
+
+		return resp.Body, nil
+	}
+
+	if strings.HasPrefix(dsn, s3Prefix) {
+		return server.GetFromS3(strings.TrimPrefix(dsn, s3Prefix))
+	}
+
+	return os.Open(dsn)
+}
+
+// fileColumn Typed column of file source
+type fileColumn struct {
+	name string
+	kind string
+}
+
+func parseFileColumns(raw string) []fileColumn {
+	var columns []fileColumn
+	for _, item := range strings.Split(raw, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		name, kind, _ := strings.Cut(item, ":")
+		columns = append(columns, fileColumn{name: strings.TrimSpace(name), kind: strings.TrimSpace(kind)})
+	}
+	return columns
+}
+
+var dateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+// convert Cast value to column type, empty values become null
+func (c fileColumn) convert(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	s := strings.TrimSpace(valueString(value))
+
+	switch c.kind {
+	case "", "string":
+		if _, ok := value.(string); ok {
+			return value, nil
+		}
+		return s, nil
+	}
+
+	if s == "" {
+		return nil, nil
+	}
+
+	switch c.kind {
+	case "int":
+		return strconv.ParseInt(s, 10, 64)
+	case "float":
+		return strconv.ParseFloat(s, 64)
+	case "bool":
+		return strconv.ParseBool(s)
+	case "date":
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return nil, errors.New("can't parse date " + s)
+	}
+
+	return nil, errors.New("unknown column type " + c.kind)
+}
+
+// fileReader Reads rows of csv, json and ndjson files
+type fileReader struct {
+	endpoint DataSourceEndpoint
+}
+
+func (r fileReader) Rows(query RowsQuery) ([]map[string]interface{}, error) {
+	rows, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return memoryRows(rows, query), nil
+}
+
+func (r fileReader) Count(query RowsQuery) (int64, error) {
+	rows, err := r.load()
+	if err != nil {
+		return 0, err
+	}
+
+	return memoryCount(rows, query), nil
+}
+
+// Page Rows and total count from one file load
+func (r fileReader) Page(query RowsQuery) ([]map[string]interface{}, int64, error) {
+	rows, err := r.load()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return memoryRows(rows, query), memoryCount(rows, query), nil
+}
+
+func (r fileReader) load() ([]map[string]interface{}, error) {
+	dsn, err := r.endpoint.DataSource.dsn()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := openSourceFile(dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := file.Close()
+		err2.DebugErr(err)
+	}()
+
+	columns := parseFileColumns(r.endpoint.Columns)
+
+	var rows []map[string]interface{}
+	switch r.endpoint.DataSource.Type {
+	case DSTypeCSV:
+		rows, err = parseCsvRows(file, r.endpoint.Delimiter, r.endpoint.NoHeader, columns)
+	case DSTypeJSON:
+		rows, err = parseJsonRows(file, r.endpoint.RowSelector)
+	case DSTypeNDJSON:
+		rows, err = parseNdjsonRows(file)
+	default:
+		err = errors.New("unsupported file type " + string(r.endpoint.DataSource.Type))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return typeRows(rows, columns)
+}
+
+// parseCsvRows Read csv records. Column names are taken from header row or from columns list
+// when file has no header, unnamed columns are named column_<number>
+func parseCsvRows(file io.Reader, delimiter string, noHeader bool, columns []fileColumn) ([]map[string]interface{}, error) {
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	switch delimiter {
+	case "":
+	case "\\t":
+		reader.Comma = '\t'
+	default:
+		comma, _ := utf8.DecodeRuneInString(delimiter)
+		reader.Comma = comma
+	}
+
+	var names []string
+	if noHeader {
+		for _, column := range columns {
+			names = append(names, column.name)
+		}
+	} else {
+		header, err := reader.Read()
+		if err == io.EOF {
+			return make([]map[string]interface{}, 0), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range header {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+
+	rows := make([]map[string]interface{}, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{}, len(record))
+		for i, value := range record {
+			name := "column_" + strconv.Itoa(i+1)
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+			row[name] = value
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// parseJsonRows Read array of objects, selector is dotted path to array inside document
+func parseJsonRows(file io.Reader, selector string) ([]map[string]interface{}, error) {
+	decoder := json.NewDecoder(file)
+	decoder.UseNumber()
+
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	return selectJsonRows(doc, selector)
+}
+
+// selectJsonRows Find rows array by dotted path
+func selectJsonRows(doc interface{}, selector string) ([]map[string]interface{}, error) {
+	for _, key := range strings.Split(selector, ".") {
+		if key == "" {
+			continue
+		}
+
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("can't find " + selector + " in json")
+		}
+		doc = obj[key]
+	}
+
+	items, ok := doc.([]interface{})
+	if !ok {
+		return nil, errors.New("json rows must be array of objects")
+	}
+
+	rows := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		row, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("json rows must be array of objects")
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// parseNdjsonRows Read one object per line
+func parseNdjsonRows(file io.Reader) ([]map[string]interface{}, error) {
+	decoder := json.NewDecoder(file)
+	decoder.UseNumber()
+
+	rows := make([]map[string]interface{}, 0)
+	for {
+		var row map[string]interface{}
+		err := decoder.Decode(&row)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if row != nil {
+			rows = append(rows, row)
+		}
+	}
+
+	return rows, nil
+}
+
+// typeRows Cast values of typed columns
+func typeRows(rows []map[string]interface{}, columns []fileColumn) ([]map[string]interface{}, error) {
+	for i, row := range rows {
+		for _, column := range columns {
+			value, ok := row[column.name]
+			if !ok {
+				continue
+			}
+
+			converted, err := column.convert(value)
+			if err != nil {
+				return nil, errors.New("row " + strconv.Itoa(i+1) + " column " + column.name + ": " + err.Error())
+			}
+			row[column.name] = converted
+		}
+	}
+
+	return rows, nil
+}
@@ -5,14 +5,18 @@ import (
 	"db-server/server/db"
 	"db-server/utils"
 	"encoding/json"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 func AddAdminRoutes(admin *mux.Router) {
+	addSyncAdminRoutes(admin)
+
 	admin.HandleFunc("/ds/dse/{dsId}", listDse).Methods(http.MethodGet, http.MethodOptions)           // each request calls PushHandler
 	admin.HandleFunc("/ds/dse/{dsId}", createDse).Methods(http.MethodPost, http.MethodOptions)        // each request calls PushHandler
 	admin.HandleFunc("/ds/dse/{dsId}/{id}", dseItem).Methods(http.MethodGet, http.MethodOptions)      // each request calls PushHandler
@@ -24,10 +28,20 @@ func AddAdminRoutes(admin *mux.Router) {
 	admin.HandleFunc("/ds/{id}", dsItem).Methods(http.MethodGet, http.MethodOptions)      // each request calls PushHandler
 	admin.HandleFunc("/ds/{id}", deleteDs).Methods(http.MethodDelete, http.MethodOptions) // each request calls PushHandler
 	admin.HandleFunc("/ds/{id}", updateDs).Methods(http.MethodPut, http.MethodOptions)    // each request calls PushHandler
+
+	admin.HandleFunc("/ds/{id}/cache", purgeDsCache).Methods(http.MethodDelete, http.MethodOptions)        // each request calls PushHandler
+	admin.HandleFunc("/ds/{id}/schema", dsSchema).Methods(http.MethodGet, http.MethodOptions)              // each request calls PushHandler
+	admin.HandleFunc("/ds/{id}/endpoints", createDsEndpoints).Methods(http.MethodPost, http.MethodOptions) // each request calls PushHandler
+	admin.HandleFunc("/ds/{id}/test", testDsConnection).Methods(http.MethodPost, http.MethodOptions)       // each request calls PushHandler
 }
 
 func AddPublicApiRoutes(r *mux.Router) {
 	r.HandleFunc("/dse/{id}", publicDesItem).Methods(http.MethodGet, http.MethodOptions) // each request calls PushHandler
+
+	r.HandleFunc("/dse/{id}/rows", createDseRow).Methods(http.MethodPost, http.MethodOptions)        // each request calls PushHandler
+	r.HandleFunc("/dse/{id}/rows/{pk}", dseRow).Methods(http.MethodGet, http.MethodOptions)          // each request calls PushHandler
+	r.HandleFunc("/dse/{id}/rows/{pk}", updateDseRow).Methods(http.MethodPut, http.MethodOptions)    // each request calls PushHandler
+	r.HandleFunc("/dse/{id}/rows/{pk}", deleteDseRow).Methods(http.MethodDelete, http.MethodOptions) // each request calls PushHandler
 }
 
 // listDs godoc
@@ -75,6 +89,121 @@ func listDse(w http.ResponseWriter, r *http.Request) {
 	err2.DebugErr(err)
 }
 
+// purgeDsCache godoc
+// @Summary      Purge data source cache
+// @Description  Remove cached results of data source endpoints
+// @Tags         Data source
+// @Tags         Admin
+// @Produce      json
+// @Param        id    path     string  true  "Ds id" id
+// @Security bearerAuth
+// @Success      200  {object}   map[string]int
+//
+// @Router       /admin/ds/{id}/cache [delete]
+func purgeDsCache(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	cnt := PurgeCache(mux.Vars(r)["id"])
+
+	w.Header().Set("Content-Type", "application/json")
+	utils.SendResponse(w, 200, map[string]int{"purged": cnt}, nil)
+}
+
+// dsSchema godoc
+// @Summary      Data source schema
+// @Description  List tables and views of sql data source with columns, types and primary keys
+// @Tags         Data source
+// @Tags         Admin
+// @Produce      json
+// @Param        id    path     string  true  "Ds id" id
+// @Security bearerAuth
+// @Success      200  {array}   TableInfo
+//
+// @Router       /admin/ds/{id}/schema [get]
+func dsSchema(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	source, err := getSource(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	tables, err := source.GetSchema()
+
+	w.Header().Set("Content-Type", "application/json")
+	utils.SendResponse(w, 200, tables, err)
+}
+
+type createEndpointsRequest struct {
+	// Tables to create endpoints for
+	// example: ["users","orders"]
+	Tables []string `json:"tables"`
+}
+
+// createDsEndpoints godoc
+// @Summary      Create endpoints for tables
+// @Description  Create endpoints for selected tables of sql data source, tables with existing endpoints are skipped
+// @Tags         Data source
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        id    path     string  true  "Ds id" id
+// @Param        tables    body     createEndpointsRequest  true  "Tables list" true
+// @Security bearerAuth
+// @Success      200  {array}   DataSourceEndpoint
+// @Failure      400  {string}   string
+//
+// @Router       /admin/ds/{id}/endpoints [post]
+func createDsEndpoints(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	source, err := getSource(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	var request createEndpointsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Tables) == 0 {
+		http.Error(w, "tables list required", 400)
+		return
+	}
+
+	endpoints, err := source.CreateEndpoints(request.Tables)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	utils.SendResponse(w, 200, endpoints, nil)
+}
+
+// testDsConnection godoc
+// @Summary      Test data source connection
+// @Description  Open new connection to data source and report latency or error
+// @Tags         Data source
+// @Tags         Admin
+// @Produce      json
+// @Param        id    path     string  true  "Ds id" id
+// @Security bearerAuth
+// @Success      200  {object}   ConnectionTest
+//
+// @Router       /admin/ds/{id}/test [post]
+func testDsConnection(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	source, err := getSource(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	utils.SendResponse(w, 200, source.TestConnection(10*time.Second), nil)
+}
+
 // createDs
 // @Summary      Create data source
 // @Description  Create data source
@@ -101,7 +230,19 @@ func createDs(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 400)
 		return
 	}
+
+	if err := model.sealHeaders(nil); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if err := model.sealDsn(nil); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
 	db.MetaDb.GetConnection().Create(&model)
+	model.maskDsn()
 
 	resp, _ := json.Marshal(model)
 	w.WriteHeader(200)
@@ -151,6 +292,14 @@ func createDse(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 400)
 		return
 	}
+
+	if model.Query != "" {
+		if err := ValidateReadOnlySql(model.Query); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
 	db.MetaDb.GetConnection().Create(&model)
 
 	resp, _ := json.Marshal(model)
@@ -205,6 +354,8 @@ func dseItem(w http.ResponseWriter, r *http.Request) {
 //
 // @Router       /admin/ds/{id} [delete]
 func deleteDs(w http.ResponseWriter, r *http.Request) {
+	EvictConnection(mux.Vars(r)["id"])
+	PurgeCache(mux.Vars(r)["id"])
 	utils.DeleteItem(DataSource{}, w, r)
 }
 
@@ -222,7 +373,8 @@ func deleteDs(w http.ResponseWriter, r *http.Request) {
 //
 // @Router       /ds/dse/{dsId}/{id} [delete]
 func deleteDse(w http.ResponseWriter, r *http.Request) {
-	utils.DeleteItem(DataSource{}, w, r)
+	PurgeCache(mux.Vars(r)["dsId"])
+	utils.DeleteItem(DataSourceEndpoint{}, w, r)
 }
 
 // updateDs
@@ -241,7 +393,7 @@ func deleteDse(w http.ResponseWriter, r *http.Request) {
 func updateDs(w http.ResponseWriter, r *http.Request) {
 	log.Debug(r.Method, r.RequestURI)
 	vars := mux.Vars(r)
-	exist, err := DataSource{}.GetById(vars["id"])
+	exist, err := getSource(vars["id"])
 
 	if err != nil {
 		w.WriteHeader(404)
@@ -252,9 +404,22 @@ func updateDs(w http.ResponseWriter, r *http.Request) {
 
 	err = json.NewDecoder(r.Body).Decode(&newm)
 
-	newm.CreatedAt = exist.(DataSource).CreatedAt
+	newm.CreatedAt = exist.CreatedAt
+
+	if err := newm.sealHeaders(&exist); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if err := newm.sealDsn(&exist); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
 
 	db.MetaDb.GetConnection().Save(&newm)
+	newm.maskDsn()
+	EvictConnection(vars["id"])
+	PurgeCache(vars["id"])
 
 	resp, _ := json.Marshal(newm)
 	w.WriteHeader(200)
@@ -292,7 +457,15 @@ func updateDse(w http.ResponseWriter, r *http.Request) {
 
 	newm.CreatedAt = exist.(DataSourceEndpoint).CreatedAt
 
+	if newm.Query != "" {
+		if err := ValidateReadOnlySql(newm.Query); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
 	db.MetaDb.GetConnection().Save(&newm)
+	PurgeCache(exist.(DataSourceEndpoint).DataSourceId.String())
 
 	resp, _ := json.Marshal(newm)
 	w.WriteHeader(200)
@@ -307,9 +480,18 @@ func updateDse(w http.ResponseWriter, r *http.Request) {
 // @Tags         Public Api
 // @Accept       json
 // @Produce      json
-// @Param        db-key    header     string  true  "Auth key" gg
+// @Param        db-key    header     string  false  "Project key, required in key access mode" gg
+// @Param        Authorization    header     string  false  "Bearer token, required in user and admin access modes" gg
 // @Param        id    path     string  true  "Source id"
-// @Success      200  {object}   project.Project
+// @Param        _start    query     int  false  "Start offset" 0
+// @Param        _end    query     int  false  "End offset" 10
+// @Param        _sort    query     string  false  "Sort column, one of endpoint filter columns"
+// @Param        _order    query     string  false  "Sort order" Enums(ASC, DESC)
+// @Param        column    query     string  false  "Filter by column, operators are column_ne, column_gt, column_gte, column_lt, column_lte, column_like, column_in"
+// @Param        _group    query     string  false  "Group by columns separated by comma"
+// @Param        _agg    query     string  false  "Aggregates separated by comma: count, count:column, sum:column, avg:column, min:column, max:column. Result columns are count and function_column"
+// @Success      200  {array}   object
+// @Failure      400  {string}   string
 //
 // @Router       /dse/{id} [get]
 func publicDesItem(w http.ResponseWriter, r *http.Request) {
@@ -325,11 +507,39 @@ func publicDesItem(w http.ResponseWriter, r *http.Request) {
 
 	model := m.(DataSourceEndpoint)
 
-	arr, _ := model.List(10, 0, "id", "ASC", make(map[string]string))
-	total := model.Total()
-	w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count")
+	if !checkEndpointAccess(w, r, model) {
+		return
+	}
+
+	query, err := model.ParseRowsQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	arr, total, hit, err := model.CachedRows(query)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if query.isAggregated() {
+		arr = model.getProjection().applyAggregated(arr, query)
+	} else {
+		arr = model.getProjection().apply(arr)
+	}
+
+	if model.DataSource.Cache {
+		if hit {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+	}
+
+	w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, X-Cache")
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.Header().Add("X-Total-Count", strconv.FormatInt(*total, 10))
+	w.Header().Add("X-Total-Count", strconv.FormatInt(total, 10))
 
 	resp, _ := json.Marshal(arr)
 
@@ -337,3 +547,183 @@ func publicDesItem(w http.ResponseWriter, r *http.Request) {
 	_, err = w.Write(resp)
 	err2.DebugErr(err)
 }
+
+// getRowsWriter Load endpoint from request and open its table after access check, write requires writable endpoint
+func getRowsWriter(w http.ResponseWriter, r *http.Request, write bool) (*tableWriter, bool) {
+	m, err := DataSourceEndpoint{}.GetById(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(404)
+		return nil, false
+	}
+
+	if !checkEndpointAccess(w, r, m.(DataSourceEndpoint)) {
+		return nil, false
+	}
+
+	writer, err := m.(DataSourceEndpoint).getWriter(write)
+	if err != nil {
+		sendRowsError(w, err)
+		return nil, false
+	}
+
+	return writer, true
+}
+
+func sendRowsError(w http.ResponseWriter, err error) {
+	var inputErr InputError
+
+	switch {
+	case errors.Is(err, ErrReadOnly):
+		utils.Send403Error(w, err.Error())
+	case errors.Is(err, ErrRowNotFound):
+		http.Error(w, err.Error(), 404)
+	case errors.As(err, &inputErr):
+		http.Error(w, err.Error(), 400)
+	default:
+		log.Error(err)
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+// createDseRow godoc
+// @Summary      Create row
+// @Description  Create row in external table of writable endpoint
+// @Tags         Data source
+// @Tags         Public Api
+// @Accept       json
+// @Produce      json
+// @Param        db-key    header     string  false  "Project key, required in key access mode" gg
+// @Param        Authorization    header     string  false  "Bearer token, required in user and admin access modes" gg
+// @Param        id    path     string  true  "Endpoint id"
+// @Param        row    body     object  true  "Row values of writable columns"
+// @Success      201  {object}   object
+// @Failure      400  {string}   string
+// @Failure      403  {object}   object
+//
+// @Router       /dse/{id}/rows [post]
+func createDseRow(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	writer, ok := getRowsWriter(w, r, true)
+	if !ok {
+		return
+	}
+
+	var payload map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	row, err := writer.Create(payload)
+	if err != nil {
+		sendRowsError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	utils.SendResponse(w, 201, row, nil)
+}
+
+// dseRow godoc
+// @Summary      Get row
+// @Description  Get row of sql table endpoint by primary key
+// @Tags         Data source
+// @Tags         Public Api
+// @Accept       json
+// @Produce      json
+// @Param        db-key    header     string  false  "Project key, required in key access mode" gg
+// @Param        Authorization    header     string  false  "Bearer token, required in user and admin access modes" gg
+// @Param        id    path     string  true  "Endpoint id"
+// @Param        pk    path     string  true  "Primary key value"
+// @Success      200  {object}   object
+// @Failure      404  {string}   string
+//
+// @Router       /dse/{id}/rows/{pk} [get]
+func dseRow(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	writer, ok := getRowsWriter(w, r, false)
+	if !ok {
+		return
+	}
+
+	row, err := writer.Get(mux.Vars(r)["pk"])
+	if err != nil {
+		sendRowsError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	utils.SendResponse(w, 200, row, nil)
+}
+
+// updateDseRow godoc
+// @Summary      Update row
+// @Description  Update row of writable endpoint by primary key
+// @Tags         Data source
+// @Tags         Public Api
+// @Accept       json
+// @Produce      json
+// @Param        db-key    header     string  false  "Project key, required in key access mode" gg
+// @Param        Authorization    header     string  false  "Bearer token, required in user and admin access modes" gg
+// @Param        id    path     string  true  "Endpoint id"
+// @Param        pk    path     string  true  "Primary key value"
+// @Param        row    body     object  true  "Row values of writable columns"
+// @Success      200  {object}   object
+// @Failure      400  {string}   string
+// @Failure      404  {string}   string
+//
+// @Router       /dse/{id}/rows/{pk} [put]
+func updateDseRow(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	writer, ok := getRowsWriter(w, r, true)
+	if !ok {
+		return
+	}
+
+	var payload map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	row, err := writer.Update(mux.Vars(r)["pk"], payload)
+	if err != nil {
+		sendRowsError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	utils.SendResponse(w, 200, row, nil)
+}
+
+// deleteDseRow godoc
+// @Summary      Delete row
+// @Description  Delete row of writable endpoint by primary key
+// @Tags         Data source
+// @Tags         Public Api
+// @Param        db-key    header     string  false  "Project key, required in key access mode" gg
+// @Param        Authorization    header     string  false  "Bearer token, required in user and admin access modes" gg
+// @Param        id    path     string  true  "Endpoint id"
+// @Param        pk    path     string  true  "Primary key value"
+// @Success      204
+// @Failure      404  {string}   string
+//
+// @Router       /dse/{id}/rows/{pk} [delete]
+func deleteDseRow(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	writer, ok := getRowsWriter(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := writer.Delete(mux.Vars(r)["pk"]); err != nil {
+		sendRowsError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
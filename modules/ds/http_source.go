@@ -0,0 +1,319 @@
+package ds
+
+import (
+	err2 "db-server/err"
+	"db-server/utils"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maskedHeader Value of auth header in api responses, sending it back keeps stored value
+const maskedHeader = "***"
+
+var httpSourceClient = &http.Client{Timeout: 30 * time.Second}
+
+// authHeaders Decrypt stored auth headers
+func (p DataSource) authHeaders() (map[string]string, error) {
+	headers := make(map[string]string)
+	if p.AuthHeaders == "" {
+		return headers, nil
+	}
+
+	master, err := utils.GetMasterKey()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(p.AuthHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := utils.Decrypt(master, data)
+	if err != nil {
+		return nil, errors.New("can't decrypt auth headers, check MASTER_KEY")
+	}
+
+	return headers, json.Unmarshal(raw, &headers)
+}
+
+// sealHeaders Encrypt headers from request into stored value. Masked values keep stored ones,
+// stored headers are kept when request has no headers
+func (p *DataSource) sealHeaders(exist *DataSource) error {
+	if exist != nil {
+		if p.Headers == nil {
+			p.AuthHeaders = exist.AuthHeaders
+			p.maskHeaders()
+			return nil
+		}
+	}
+
+	if len(p.Headers) == 0 {
+		p.AuthHeaders = ""
+		return nil
+	}
+
+	current := make(map[string]string)
+	if exist != nil {
+		var err error
+		if current, err = exist.authHeaders(); err != nil {
+			return err
+		}
+	}
+
+	headers := make(map[string]string, len(p.Headers))
+	for name, value := range p.Headers {
+		if value == maskedHeader {
+			value = current[name]
+		}
+		headers[name] = value
+	}
+
+	raw, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+
+	master, err := utils.GetMasterKey()
+	if err != nil {
+		return err
+	}
+
+	data, err := utils.Encrypt(master, raw)
+	if err != nil {
+		return err
+	}
+
+	p.AuthHeaders = base64.StdEncoding.EncodeToString(data)
+	p.maskHeaders()
+
+	return nil
+}
+
+// maskHeaders Show stored header names with masked values
+func (p *DataSource) maskHeaders() {
+	headers, err := p.authHeaders()
+	err2.DebugErr(err)
+
+	p.Headers = make(map[string]string, len(headers))
+	for name := range headers {
+		p.Headers[name] = maskedHeader
+	}
+}
+
+// request Request upstream api with auth headers
+func (p DataSource) request(path string, params url.Values) (*http.Response, error) {
+	base, err := p.dsn()
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := url.Parse(strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(params) > 0 {
+		query := target.Query()
+		for name, values := range params {
+			for _, value := range values {
+				query.Add(name, value)
+			}
+		}
+		target.RawQuery = query.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	headers, err := p.authHeaders()
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	return httpSourceClient.Do(req)
+}
+
+// httpReader Reads rows from upstream http api
+type httpReader struct {
+	endpoint DataSourceEndpoint
+}
+
+func (r httpReader) Rows(query RowsQuery) ([]map[string]interface{}, error) {
+	rows, _, err := r.Page(query)
+	return rows, err
+}
+
+func (r httpReader) Count(query RowsQuery) (int64, error) {
+	_, total, err := r.Page(query)
+	return total, err
+}
+
+// Page Rows and total count with one upstream request when possible.
+// Without upstream pagination all rows are loaded and filtered, sorted and paginated in memory,
+// with upstream pagination only equality filters are supported and forwarded as query params
+func (r httpReader) Page(query RowsQuery) ([]map[string]interface{}, int64, error) {
+	e := r.endpoint
+
+	if e.Pagination == "" {
+		rows, _, err := r.fetch(url.Values{})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return memoryRows(rows, query), memoryCount(rows, query), nil
+	}
+
+	if query.Sort != "" || query.isAggregated() {
+		return nil, 0, errors.New("sorting and aggregation are not supported with upstream pagination")
+	}
+
+	params := url.Values{}
+	for _, f := range query.Filters {
+		if f.Operator != "eq" {
+			return nil, 0, errors.New("only equality filters are supported with upstream pagination")
+		}
+		params.Set(f.Column, f.Value)
+	}
+
+	first, second := e.paginationParams()
+
+	switch e.Pagination {
+	case "offset":
+		params.Set(first, strconv.Itoa(query.Offset))
+		params.Set(second, strconv.Itoa(query.Limit))
+
+		rows, total, err := r.fetch(params)
+		if total < 0 {
+			total = int64(query.Offset + len(rows))
+		}
+		return rows, total, err
+
+	case "page":
+		if query.Limit <= 0 {
+			return make([]map[string]interface{}, 0), 0, nil
+		}
+
+		// load pages covering requested range
+		rows := make([]map[string]interface{}, 0)
+		total := int64(-1)
+		page := query.Offset/query.Limit + 1
+		skip := query.Offset % query.Limit
+
+		for len(rows) < skip+query.Limit {
+			params.Set(first, strconv.Itoa(page))
+			params.Set(second, strconv.Itoa(query.Limit))
+
+			pageRows, pageTotal, err := r.fetch(params)
+			if err != nil {
+				return nil, 0, err
+			}
+			total = pageTotal
+			rows = append(rows, pageRows...)
+
+			if len(pageRows) < query.Limit || skip == 0 {
+				break
+			}
+			page++
+		}
+
+		if skip < len(rows) {
+			rows = rows[skip:]
+		} else {
+			rows = rows[:0]
+		}
+		if len(rows) > query.Limit {
+			rows = rows[:query.Limit]
+		}
+
+		if total < 0 {
+			total = int64(query.Offset + len(rows))
+		}
+		return rows, total, nil
+	}
+
+	return nil, 0, errors.New("unknown pagination " + e.Pagination)
+}
+
+// paginationParams Upstream params names of offset and limit or page and page size
+func (e DataSourceEndpoint) paginationParams() (string, string) {
+	first, second, _ := strings.Cut(e.PaginationParams, ",")
+	first, second = strings.TrimSpace(first), strings.TrimSpace(second)
+
+	if first == "" || second == "" {
+		if e.Pagination == "page" {
+			return "page", "per_page"
+		}
+		return "offset", "limit"
+	}
+
+	return first, second
+}
+
+// fetch Request endpoint path and select rows. Total is read from X-Total-Count header or
+// total path of response, -1 when unknown
+func (r httpReader) fetch(params url.Values) ([]map[string]interface{}, int64, error) {
+	resp, err := r.endpoint.DataSource.request(r.endpoint.SourceTable, params)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		err := resp.Body.Close()
+		err2.DebugErr(err)
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, errors.New("upstream responded " + resp.Status)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := selectJsonRows(doc, r.endpoint.RowSelector)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err = typeRows(rows, parseFileColumns(r.endpoint.Columns))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := int64(-1)
+	if header := resp.Header.Get("X-Total-Count"); header != "" {
+		if cnt, err := strconv.ParseInt(header, 10, 64); err == nil {
+			total = cnt
+		}
+	}
+
+	if r.endpoint.TotalPath != "" {
+		value := doc
+		for _, key := range strings.Split(r.endpoint.TotalPath, ".") {
+			obj, _ := value.(map[string]interface{})
+			value = obj[key]
+		}
+		if cnt, err := strconv.ParseInt(valueString(value), 10, 64); err == nil {
+			total = cnt
+		}
+	}
+
+	return rows, total, nil
+}
@@ -0,0 +1,168 @@
+package ds
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"db-server/utils"
+	"encoding/hex"
+	"strings"
+)
+
+// projection Visible columns with output names and masks of endpoint
+type projection struct {
+	columns []string
+	aliases map[string]string
+	masks   map[string]string
+}
+
+// getProjection Parse visible columns list "column:alias;column" and masks list "column:mask"
+func (e DataSourceEndpoint) getProjection() projection {
+	p := projection{aliases: make(map[string]string), masks: make(map[string]string)}
+
+	for _, item := range splitColumns(e.VisibleColumns) {
+		column, alias, _ := strings.Cut(item, ":")
+		column, alias = strings.TrimSpace(column), strings.TrimSpace(alias)
+		if alias == "" {
+			alias = column
+		}
+
+		p.columns = append(p.columns, column)
+		p.aliases[column] = alias
+	}
+
+	for _, item := range splitColumns(e.Masks) {
+		column, mask, _ := strings.Cut(item, ":")
+		p.masks[strings.TrimSpace(column)] = strings.TrimSpace(mask)
+	}
+
+	return p
+}
+
+// sourceColumn Source column name of output name
+func (p projection) sourceColumn(name string) string {
+	for column, alias := range p.aliases {
+		if alias == name {
+			return column
+		}
+	}
+
+	if len(p.columns) > 0 {
+		if _, ok := p.aliases[name]; ok {
+			return name
+		}
+		// hidden column
+		return ""
+	}
+
+	return name
+}
+
+// sourceRow Rename payload keys from output names to source columns
+func (p projection) sourceRow(payload map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(payload))
+	for name, value := range payload {
+		if column := p.sourceColumn(name); column != "" {
+			res[column] = value
+		} else {
+			res[name] = value
+		}
+	}
+	return res
+}
+
+// apply Project and mask rows, all columns are visible when no columns configured
+func (p projection) apply(rows []map[string]interface{}) []map[string]interface{} {
+	res := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		res[i] = p.applyRow(row)
+	}
+	return res
+}
+
+func (p projection) applyRow(row map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(row))
+
+	if len(p.columns) == 0 {
+		for column, value := range row {
+			res[column] = maskValue(value, p.masks[column])
+		}
+		return res
+	}
+
+	for _, column := range p.columns {
+		if value, ok := row[column]; ok {
+			res[p.aliases[column]] = maskValue(value, p.masks[column])
+		}
+	}
+
+	return res
+}
+
+// maskValue Apply mask: email, phone, partial, hash or null
+func maskValue(value interface{}, mask string) interface{} {
+	if mask == "" || value == nil {
+		return value
+	}
+
+	s := valueString(value)
+
+	switch mask {
+	case "null":
+		return nil
+	case "hash":
+		// keyed hash can't be brute forced without server secret, hidden when secret is not set
+		master, err := utils.GetMasterKey()
+		if err != nil {
+			return nil
+		}
+		key := sha256.Sum256(append([]byte("mask"), master...))
+		mac := hmac.New(sha256.New, key[:])
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil))
+	case "email":
+		local, domain, ok := strings.Cut(s, "@")
+		if !ok {
+			return maskPartial(s, 1, 0)
+		}
+		return maskPartial(local, 1, 0) + "@" + domain
+	case "phone":
+		return maskPhone(s)
+	case "partial":
+		return maskPartial(s, 2, 2)
+	}
+
+	return value
+}
+
+// maskPartial Replace all chars except first and last ones with *
+func maskPartial(s string, first int, last int) string {
+	chars := []rune(s)
+	if len(chars) <= first+last {
+		return strings.Repeat("*", len(chars))
+	}
+
+	for i := first; i < len(chars)-last; i++ {
+		chars[i] = '*'
+	}
+
+	return string(chars)
+}
+
+// maskPhone Replace all digits except last four with *
+func maskPhone(s string) string {
+	chars := []rune(s)
+
+	digits := 0
+	for i := len(chars) - 1; i >= 0; i-- {
+		if chars[i] < '0' || chars[i] > '9' {
+			continue
+		}
+
+		digits++
+		if digits > 4 {
+			chars[i] = '*'
+		}
+	}
+
+	return string(chars)
+}
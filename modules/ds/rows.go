@@ -0,0 +1,603 @@
+package ds
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRowsLimit Max rows returned by one request
+const maxRowsLimit = 1000
+
+// RowsFilter Condition on endpoint column
+type RowsFilter struct {
+	Column   string
+	Operator string
+	Value    string
+}
+
+// RowsQuery Endpoint rows request params
+type RowsQuery struct {
+	Limit   int
+	Offset  int
+	Sort    string
+	Order   string
+	Filters []RowsFilter
+	// Named params of sql query endpoints
+	Params map[string]interface{}
+	// Group by source columns
+	GroupBy    []string
+	Aggregates []RowsAggregate
+	// Tie-breaker sort column, unique with sort column
+	Key string
+	// Keyset pagination, rows after this row by sort and key columns instead of offset
+	After map[string]interface{}
+}
+
+// filterOperators Operators available as column_<operator> query params, column=value means eq
+var filterOperators = []string{"ne", "gte", "gt", "lte", "lt", "like", "in"}
+
+// ParseRowsQuery Read _start, _end, _sort, _order, _group, _agg and filters on whitelisted columns from query params
+func (e DataSourceEndpoint) ParseRowsQuery(values url.Values) (RowsQuery, error) {
+	query := RowsQuery{Limit: 10, Order: "ASC"}
+
+	if start, err := strconv.Atoi(values.Get("_start")); err == nil && start > 0 {
+		query.Offset = start
+	}
+	if end, err := strconv.Atoi(values.Get("_end")); err == nil {
+		query.Limit = end - query.Offset
+	}
+	if query.Limit < 0 {
+		query.Limit = 0
+	}
+	if query.Limit > maxRowsLimit {
+		query.Limit = maxRowsLimit
+	}
+
+	if strings.ToUpper(values.Get("_order")) == "DESC" {
+		query.Order = "DESC"
+	}
+
+	if e.Query != "" {
+		params, err := e.parseQueryParams(values)
+		if err != nil {
+			return query, err
+		}
+		query.Params = params
+	}
+
+	// params use output names of columns
+	project := e.getProjection()
+	columns := make(map[string]bool)
+	for _, column := range splitColumns(e.FilterColumns) {
+		if name, ok := project.aliases[column]; ok {
+			columns[name] = true
+		} else if len(project.columns) == 0 {
+			columns[column] = true
+		}
+	}
+
+	if err := query.parseAggregation(values, columns, project); err != nil {
+		return query, err
+	}
+
+	if sortColumn := values.Get("_sort"); sortColumn != "" {
+		query.Sort = ""
+		if query.isAggregated() {
+			for _, agg := range query.Aggregates {
+				if agg.Alias == sortColumn {
+					query.Sort = agg.Alias
+				}
+			}
+			for _, column := range query.GroupBy {
+				if columns[sortColumn] && column == project.sourceColumn(sortColumn) {
+					query.Sort = column
+				}
+			}
+		} else if columns[sortColumn] {
+			query.Sort = project.sourceColumn(sortColumn)
+		}
+
+		// order of masked values reveals them
+		if query.Sort == "" || project.masks[query.Sort] != "" {
+			return query, errors.New("sorting by " + sortColumn + " is not allowed")
+		}
+	}
+
+	for key, items := range values {
+		if strings.HasPrefix(key, "_") || len(items) == 0 {
+			continue
+		}
+		if _, ok := query.Params[key]; ok {
+			continue
+		}
+
+		column, operator := key, "eq"
+		for _, op := range filterOperators {
+			if strings.HasSuffix(key, "_"+op) && columns[strings.TrimSuffix(key, "_"+op)] {
+				column, operator = strings.TrimSuffix(key, "_"+op), op
+				break
+			}
+		}
+
+		if !columns[column] {
+			continue
+		}
+
+		// filters confirm guessed masked values
+		source := project.sourceColumn(column)
+		if project.masks[source] != "" {
+			return query, errors.New("filter " + key + " on masked column is not allowed")
+		}
+
+		query.Filters = append(query.Filters, RowsFilter{Column: source, Operator: operator, Value: items[0]})
+	}
+
+	sort.Slice(query.Filters, func(i, j int) bool {
+		return query.Filters[i].Column+query.Filters[i].Operator < query.Filters[j].Column+query.Filters[j].Operator
+	})
+
+	return query, nil
+}
+
+func splitColumns(raw string) []string {
+	var columns []string
+	for _, column := range strings.Split(raw, ";") {
+		column = strings.TrimSpace(column)
+		if column != "" {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// rowsReader Reads rows of endpoint source
+type rowsReader interface {
+	Rows(query RowsQuery) ([]map[string]interface{}, error)
+	Count(query RowsQuery) (int64, error)
+}
+
+// pageReader Reader which loads rows and total count at once
+type pageReader interface {
+	Page(query RowsQuery) ([]map[string]interface{}, int64, error)
+}
+
+// page Rows and total count of query
+func (e DataSourceEndpoint) page(query RowsQuery) ([]map[string]interface{}, int64, error) {
+	reader, err := e.getReader()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if pr, ok := reader.(pageReader); ok {
+		return pr.Page(query)
+	}
+
+	rows, err := reader.Rows(query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := reader.Count(query)
+	return rows, total, err
+}
+
+func (e DataSourceEndpoint) getReader() (rowsReader, error) {
+	switch e.DataSource.Type {
+	case DSTypeMysql, DSTypePostgres, DSTypeSqlite:
+		conn, err := e.getConnection()
+		if err != nil {
+			return nil, err
+		}
+
+		if e.Query != "" {
+			if err := ValidateReadOnlySql(e.Query); err != nil {
+				return nil, err
+			}
+
+			timeout := e.Timeout
+			if timeout <= 0 {
+				timeout = defaultQueryTimeout
+			}
+
+			return sqlReader{conn: conn, query: e.Query, maxRows: e.MaxRows, timeout: time.Duration(timeout) * time.Second}, nil
+		}
+
+		return sqlReader{conn: conn, table: e.SourceTable}, nil
+
+	case DSTypeXML:
+		return xmlReader{endpoint: e}, nil
+
+	case DSTypeCSV, DSTypeJSON, DSTypeNDJSON:
+		return fileReader{endpoint: e}, nil
+
+	case DSTypeHTTP:
+		return httpReader{endpoint: e}, nil
+	}
+
+	return nil, errors.New("unsupported data source type " + string(e.DataSource.Type))
+}
+
+// Rows Read rows of external source
+func (e DataSourceEndpoint) Rows(query RowsQuery) ([]map[string]interface{}, error) {
+	reader, err := e.getReader()
+	if err != nil {
+		return nil, err
+	}
+
+	return reader.Rows(query)
+}
+
+// Count Count rows of external source matched by query filters
+func (e DataSourceEndpoint) Count(query RowsQuery) (int64, error) {
+	reader, err := e.getReader()
+	if err != nil {
+		return 0, err
+	}
+
+	return reader.Count(query)
+}
+
+type sqlReader struct {
+	conn  *gorm.DB
+	table string
+	// Read only statement used as table
+	query   string
+	maxRows int
+	timeout time.Duration
+}
+
+// filtered Table query with filters, columns are quoted by gorm and values are bound
+func (r sqlReader) filtered(tx *gorm.DB, query RowsQuery) *gorm.DB {
+	if r.query != "" {
+		// gorm binds params map only to queries with named params, map itself is not a value
+		statement := r.conn.Raw(r.query)
+		if len(query.Params) > 0 {
+			statement = r.conn.Raw(r.query, query.Params)
+		}
+		tx = tx.Table("(?) AS q", statement)
+	} else {
+		tx = tx.Table(r.table)
+	}
+
+	for _, f := range query.Filters {
+		column := clause.Column{Name: f.Column}
+
+		switch f.Operator {
+		case "eq":
+			tx = tx.Where(clause.Eq{Column: column, Value: f.Value})
+		case "ne":
+			tx = tx.Where(clause.Neq{Column: column, Value: f.Value})
+		case "gt":
+			tx = tx.Where(clause.Gt{Column: column, Value: f.Value})
+		case "gte":
+			tx = tx.Where(clause.Gte{Column: column, Value: f.Value})
+		case "lt":
+			tx = tx.Where(clause.Lt{Column: column, Value: f.Value})
+		case "lte":
+			tx = tx.Where(clause.Lte{Column: column, Value: f.Value})
+		case "like":
+			tx = tx.Where(clause.Like{Column: column, Value: f.Value})
+		case "in":
+			var items []interface{}
+			for _, item := range strings.Split(f.Value, ",") {
+				items = append(items, item)
+			}
+			tx = tx.Where(clause.IN{Column: column, Values: items})
+		}
+	}
+
+	return tx
+}
+
+// afterCondition Rows following After row in query order: sort past last value, or same value and key past last key
+func (query RowsQuery) afterCondition() clause.Expression {
+	sort := clause.Column{Name: query.Sort}
+	after := func(column clause.Column, value interface{}) clause.Expression {
+		if query.Order != "ASC" {
+			return clause.Lt{Column: column, Value: value}
+		}
+		return clause.Gt{Column: column, Value: value}
+	}
+
+	if query.Key == "" || query.Key == query.Sort {
+		return after(sort, query.After[query.Sort])
+	}
+
+	return clause.Or(
+		after(sort, query.After[query.Sort]),
+		clause.And(
+			clause.Eq{Column: sort, Value: query.After[query.Sort]},
+			after(clause.Column{Name: query.Key}, query.After[query.Key]),
+		),
+	)
+}
+
+// context Query context with reader timeout
+func (r sqlReader) context() (context.Context, context.CancelFunc) {
+	if r.timeout > 0 {
+		return context.WithTimeout(context.Background(), r.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func (r sqlReader) Rows(query RowsQuery) ([]map[string]interface{}, error) {
+	rows := make([]map[string]interface{}, 0)
+
+	if r.maxRows > 0 && query.Limit > r.maxRows {
+		query.Limit = r.maxRows
+	}
+
+	// pages are stable with default order only
+	order := clause.OrderByColumn{Column: clause.Column{Name: query.Sort}, Desc: query.Order != "ASC"}
+	if query.Sort == "" {
+		order.Column = r.defaultOrder(query)
+	}
+
+	ctx, cancel := r.context()
+	defer cancel()
+
+	err := r.readOnly(ctx, func(conn *gorm.DB) error {
+		tx := r.filtered(conn, query)
+		if query.isAggregated() {
+			tx = r.aggregated(tx, query)
+		}
+
+		tx = tx.Limit(query.Limit).
+			Offset(query.Offset)
+
+		tx = tx.Order(order)
+		if query.Key != "" && query.Key != query.Sort {
+			tx = tx.Order(clause.OrderByColumn{Column: clause.Column{Name: query.Key}, Desc: query.Order != "ASC"})
+		}
+		if query.After != nil {
+			tx = tx.Where(query.afterCondition())
+		}
+
+		return tx.Find(&rows).Error
+	})
+
+	return rows, err
+}
+
+// defaultOrder Primary key of table, first column of sql query, aggregated rows or table without primary key
+func (r sqlReader) defaultOrder(query RowsQuery) clause.Column {
+	if r.query == "" && !query.isAggregated() {
+		if pk, err := r.cachedPrimaryKey(); err == nil {
+			return clause.Column{Name: pk}
+		}
+	}
+
+	return clause.Column{Name: "1", Raw: true}
+}
+
+func (r sqlReader) Count(query RowsQuery) (int64, error) {
+	var cnt int64
+
+	ctx, cancel := r.context()
+	defer cancel()
+
+	err := r.readOnly(ctx, func(conn *gorm.DB) error {
+		if query.isAggregated() {
+			return conn.Table("(?) AS g", r.aggregated(r.filtered(conn, query), query)).Count(&cnt).Error
+		}
+
+		return r.filtered(conn, query).Count(&cnt).Error
+	})
+
+	return cnt, err
+}
+
+// readOnly Run reads of sql query endpoint in read only transaction, so database rejects side effects
+// of functions passed by ValidateReadOnlySql. Table endpoints read without transaction
+func (r sqlReader) readOnly(ctx context.Context, fn func(conn *gorm.DB) error) error {
+	if r.query == "" {
+		return fn(r.conn.WithContext(ctx))
+	}
+
+	// mysql starts transaction with START TRANSACTION READ ONLY, postgres with BEGIN READ ONLY.
+	// Query timeout cancels statements only, transaction stays open to restore settings and rollback
+	tx := r.conn.Begin(&sql.TxOptions{ReadOnly: true})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	// nothing to commit in read only transaction
+	defer tx.Rollback()
+
+	switch r.conn.Dialector.Name() {
+	case "postgres":
+		if err := tx.Exec("SET TRANSACTION READ ONLY").Error; err != nil {
+			return err
+		}
+	case "sqlite":
+		// query_only is connection setting, restored before connection returns to pool
+		if err := tx.Exec("PRAGMA query_only = ON").Error; err != nil {
+			return err
+		}
+		defer tx.Exec("PRAGMA query_only = OFF")
+	}
+
+	return fn(tx.WithContext(ctx))
+}
+
+// memoryRows Filter, aggregate, sort and paginate rows loaded in memory
+func memoryRows(rows []map[string]interface{}, query RowsQuery) []map[string]interface{} {
+	sorted := filterRows(rows, query.Filters)
+	if query.isAggregated() {
+		sorted = aggregateRows(sorted, query)
+	}
+
+	if query.Sort != "" {
+		sort.SliceStable(sorted, func(i, j int) bool {
+			cmp := compareValues(sorted[i][query.Sort], sorted[j][query.Sort])
+			if query.Order != "ASC" {
+				return cmp > 0
+			}
+			return cmp < 0
+		})
+	}
+
+	if query.Offset >= len(sorted) {
+		return make([]map[string]interface{}, 0)
+	}
+	sorted = sorted[query.Offset:]
+
+	if query.Limit >= 0 && query.Limit < len(sorted) {
+		sorted = sorted[:query.Limit]
+	}
+
+	return sorted
+}
+
+// memoryCount Count of rows or groups matched by query
+func memoryCount(rows []map[string]interface{}, query RowsQuery) int64 {
+	filtered := filterRows(rows, query.Filters)
+	if query.isAggregated() {
+		return int64(len(aggregateRows(filtered, query)))
+	}
+	return int64(len(filtered))
+}
+
+// filterRows Rows matched by all filters
+func filterRows(rows []map[string]interface{}, filters []RowsFilter) []map[string]interface{} {
+	res := make([]map[string]interface{}, 0, len(rows))
+
+	for _, row := range rows {
+		matched := true
+		for _, f := range filters {
+			if !matchFilter(row[f.Column], f) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			res = append(res, row)
+		}
+	}
+
+	return res
+}
+
+func matchFilter(value interface{}, f RowsFilter) bool {
+	switch f.Operator {
+	case "eq":
+		return compareValues(value, f.Value) == 0
+	case "ne":
+		return compareValues(value, f.Value) != 0
+	case "gt":
+		return compareValues(value, f.Value) > 0
+	case "gte":
+		return compareValues(value, f.Value) >= 0
+	case "lt":
+		return compareValues(value, f.Value) < 0
+	case "lte":
+		return compareValues(value, f.Value) <= 0
+	case "like":
+		return matchLike(valueString(value), f.Value)
+	case "in":
+		for _, item := range strings.Split(f.Value, ",") {
+			if compareValues(value, item) == 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// matchLike Case-insensitive sql like match, % is any string and _ is any char
+func matchLike(value string, pattern string) bool {
+	var expr strings.Builder
+	expr.WriteString("(?is)^")
+	for _, c := range pattern {
+		switch c {
+		case '%':
+			expr.WriteString(".*")
+		case '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	matched, err := regexp.MatchString(expr.String(), value)
+	return err == nil && matched
+}
+
+// compareValues Compare values of date column as time, values as numbers when both are numeric,
+// as strings otherwise
+func compareValues(a interface{}, b interface{}) int {
+	_, aDate := a.(time.Time)
+	_, bDate := b.(time.Time)
+	if aDate || bDate {
+		at, aOk := timeValue(a)
+		bt, bOk := timeValue(b)
+		if aOk && bOk {
+			return at.Compare(bt)
+		}
+	}
+
+	as, bs := valueString(a), valueString(b)
+
+	af, aErr := strconv.ParseFloat(as, 64)
+	bf, bErr := strconv.ParseFloat(bs, 64)
+	if aErr == nil && bErr == nil {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+
+	return strings.Compare(as, bs)
+}
+
+// timeValue Date of date column, or filter value parsed with date column layouts
+func timeValue(v interface{}) (time.Time, bool) {
+	switch val := v.(type) {
+	case time.Time:
+		return val, true
+	case string:
+		for _, layout := range append([]string{time.RFC3339Nano}, dateLayouts...) {
+			if t, err := time.Parse(layout, strings.TrimSpace(val)); err == nil {
+				return t, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func valueString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case int:
+		return strconv.Itoa(val)
+	case bool:
+		return strconv.FormatBool(val)
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	}
+
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,168 @@
+package ds
+
+import (
+	"db-server/server/db"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// swagger:model
+type ColumnInfo struct {
+	// Column name
+	// example: id
+	Name string `json:"name"`
+	// Database type name
+	// example: INTEGER
+	Type string `json:"type"`
+	// Column allows null
+	Nullable bool `json:"nullable"`
+	// Column is part of primary key
+	PrimaryKey bool `json:"primary_key"`
+}
+
+// swagger:model
+type TableInfo struct {
+	// Table name
+	// example: users
+	Name string `json:"name"`
+	// Table type, table or view
+	// example: table
+	Type    string       `json:"type"`
+	Columns []ColumnInfo `json:"columns"`
+}
+
+// listViews Views of current database, gorm migrator has no views list
+func listViews(conn *gorm.DB, dsType DsType) ([]string, error) {
+	var views []string
+	var tx *gorm.DB
+
+	switch dsType {
+	case DSTypeMysql:
+		tx = conn.Raw("SELECT TABLE_NAME FROM information_schema.tables WHERE TABLE_SCHEMA = DATABASE() AND TABLE_TYPE = ?", "VIEW").Scan(&views)
+	case DSTypePostgres:
+		tx = conn.Raw("SELECT table_name FROM information_schema.views WHERE table_schema = current_schema()").Scan(&views)
+	case DSTypeSqlite:
+		tx = conn.Raw("SELECT name FROM sqlite_master WHERE type = ?", "view").Scan(&views)
+	default:
+		return views, nil
+	}
+
+	return views, tx.Error
+}
+
+// GetSchema List tables and views of sql data source with columns
+func (p DataSource) GetSchema() ([]TableInfo, error) {
+	conn, err := DataSourceEndpoint{DataSource: p}.getConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	migrator := conn.Migrator()
+
+	tables, err := migrator.GetTables()
+	if err != nil {
+		return nil, err
+	}
+
+	views, err := listViews(conn, p.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	isView := make(map[string]bool)
+	for _, view := range views {
+		isView[view] = true
+	}
+
+	// mysql lists views with tables
+	names := make([]string, 0, len(tables)+len(views))
+	for _, table := range tables {
+		if !isView[table] {
+			names = append(names, table)
+		}
+	}
+	names = append(names, views...)
+
+	res := make([]TableInfo, 0, len(names))
+	for _, name := range names {
+		info := TableInfo{Name: name, Type: "table", Columns: make([]ColumnInfo, 0)}
+		if isView[name] {
+			info.Type = "view"
+		}
+
+		columnTypes, err := migrator.ColumnTypes(name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ct := range columnTypes {
+			column := ColumnInfo{Name: ct.Name(), Type: ct.DatabaseTypeName()}
+			column.Nullable, _ = ct.Nullable()
+			column.PrimaryKey, _ = ct.PrimaryKey()
+			info.Columns = append(info.Columns, column)
+		}
+
+		res = append(res, info)
+	}
+
+	return res, nil
+}
+
+// CreateEndpoints Create endpoints for tables of data source, tables which already have endpoint are skipped
+func (p DataSource) CreateEndpoints(tables []string) ([]DataSourceEndpoint, error) {
+	schema, err := p.GetSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]TableInfo)
+	for _, table := range schema {
+		known[table.Name] = table
+	}
+
+	for _, table := range tables {
+		if _, ok := known[table]; !ok {
+			return nil, errors.New("table " + table + " not found")
+		}
+	}
+
+	conn := db.MetaDb.GetConnection()
+	created := make([]DataSourceEndpoint, 0, len(tables))
+
+	for _, table := range tables {
+		var cnt int64
+		conn.Model(&DataSourceEndpoint{}).Where("data_source_id = ? AND source_table = ?", p.Id, table).Count(&cnt)
+		if cnt > 0 {
+			continue
+		}
+
+		endpoint := DataSourceEndpoint{
+			Title:        table,
+			SourceTable:  table,
+			DataSourceId: p.Id,
+		}
+		endpoint.Id, err = uuid.NewUUID()
+		if err != nil {
+			return created, err
+		}
+
+		// primary key is allowed for sorting by default
+		var keys []string
+		for _, column := range known[table].Columns {
+			if column.PrimaryKey {
+				keys = append(keys, column.Name)
+			}
+		}
+		endpoint.FilterColumns = strings.Join(keys, ";")
+
+		if tx := conn.Omit("DataSource").Create(&endpoint); tx.Error != nil {
+			return created, tx.Error
+		}
+
+		created = append(created, endpoint)
+	}
+
+	return created, nil
+}
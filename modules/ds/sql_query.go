@@ -0,0 +1,113 @@
+package ds
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	// defaultQueryTimeout Timeout in seconds of sql query endpoints
+	defaultQueryTimeout = 10
+)
+
+var (
+	sqlCommentsRe = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/`)
+	sqlStringsRe  = regexp.MustCompile(`'(?:[^']|'')*'|"(?:[^"]|"")*"|` + "`[^`]*`")
+	sqlWordsRe    = regexp.MustCompile(`[A-Za-z_]+`)
+	sqlCallsRe    = regexp.MustCompile(`([A-Za-z_][A-Za-z_0-9]*)\s*\(`)
+)
+
+// sqlWriteKeywords Statements and clauses which change data or server state
+var sqlWriteKeywords = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "UPSERT": true,
+	"CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "RENAME": true,
+	"GRANT": true, "REVOKE": true, "SET": true, "RESET": true, "LOCK": true, "UNLOCK": true,
+	"CALL": true, "EXEC": true, "EXECUTE": true, "DO": true, "HANDLER": true, "LOAD": true, "COPY": true,
+	"ATTACH": true, "DETACH": true, "PRAGMA": true, "VACUUM": true, "ANALYZE": true, "REINDEX": true,
+	"INTO": true, "OUTFILE": true, "DUMPFILE": true, "SHUTDOWN": true, "KILL": true,
+	"BEGIN": true, "COMMIT": true, "ROLLBACK": true, "SAVEPOINT": true, "RELEASE": true,
+}
+
+// sqlSideEffectFunctions Functions which change sequences, files, sessions or hold connections
+var sqlSideEffectFunctions = map[string]bool{
+	"NEXTVAL": true, "SETVAL": true, "PG_SLEEP": true, "PG_SLEEP_FOR": true, "PG_SLEEP_UNTIL": true,
+	"PG_TERMINATE_BACKEND": true, "PG_CANCEL_BACKEND": true, "PG_RELOAD_CONF": true,
+	"LO_IMPORT": true, "LO_EXPORT": true, "LO_UNLINK": true, "PG_READ_FILE": true, "PG_READ_BINARY_FILE": true,
+	"DBLINK": true, "DBLINK_EXEC": true, "SLEEP": true, "BENCHMARK": true, "LOAD_FILE": true,
+	"GET_LOCK": true, "RELEASE_LOCK": true, "PG_ADVISORY_LOCK": true, "LOAD_EXTENSION": true,
+}
+
+// ValidateReadOnlySql Check statement is single SELECT or WITH query without data changing keywords
+// and known side effect functions. It is first pass only, query endpoints also run in read only transaction
+func ValidateReadOnlySql(statement string) error {
+	s := sqlCommentsRe.ReplaceAllString(statement, " ")
+	s = sqlStringsRe.ReplaceAllString(s, "''")
+	s = strings.TrimSpace(s)
+	s = strings.TrimSpace(strings.TrimSuffix(s, ";"))
+
+	if s == "" {
+		return errors.New("query is empty")
+	}
+
+	if strings.Contains(s, ";") {
+		return errors.New("query must be single statement")
+	}
+
+	words := sqlWordsRe.FindAllString(s, -1)
+	if len(words) == 0 {
+		return errors.New("query must start with SELECT or WITH")
+	}
+
+	first := strings.ToUpper(words[0])
+	if first != "SELECT" && first != "WITH" {
+		return errors.New("query must start with SELECT or WITH")
+	}
+
+	for i, word := range words {
+		word = strings.ToUpper(word)
+		if sqlWriteKeywords[word] {
+			return errors.New("query is not read only: " + word + " is not allowed")
+		}
+
+		// SELECT ... FOR UPDATE / FOR SHARE locks rows
+		if word == "FOR" && i+1 < len(words) {
+			next := strings.ToUpper(words[i+1])
+			if next == "SHARE" || next == "NO" || next == "KEY" {
+				return errors.New("query is not read only: FOR " + next + " is not allowed")
+			}
+		}
+	}
+
+	for _, call := range sqlCallsRe.FindAllStringSubmatch(s, -1) {
+		name := strings.ToUpper(call[1])
+		if sqlSideEffectFunctions[name] {
+			return errors.New("query is not read only: " + name + " is not allowed")
+		}
+	}
+
+	return nil
+}
+
+// parseQueryParams Bind declared params of query endpoint from query string.
+// Params without value are null, values are cast to declared types
+func (e DataSourceEndpoint) parseQueryParams(values url.Values) (map[string]interface{}, error) {
+	params := make(map[string]interface{})
+
+	for _, param := range parseFileColumns(e.QueryParams) {
+		var value interface{}
+		if values.Has(param.name) {
+			value = values.Get(param.name)
+		}
+
+		converted, err := param.convert(value)
+		if err != nil {
+			return nil, errors.New("param " + param.name + ": " + err.Error())
+		}
+
+		params[param.name] = converted
+	}
+
+	return params, nil
+}
@@ -0,0 +1,100 @@
+package ds
+
+import (
+	"context"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateReadOnlySql(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		allowed bool
+	}{
+		{"select", "SELECT id, title FROM items WHERE id = @id", true},
+		{"select trailing semicolon", "SELECT 1;", true},
+		{"with", "WITH t AS (SELECT 1 AS a) SELECT a FROM t", true},
+		{"keyword in string", "SELECT 'DELETE FROM items' AS text", true},
+		{"keyword in comment", "SELECT 1 -- DROP TABLE items", true},
+		{"keyword in column name", "SELECT updated_at, created_by FROM items", true},
+		{"column named like function", "SELECT sleep FROM items", true},
+		{"empty", "  ", false},
+		{"comment only", "/* SELECT 1 */", false},
+		{"insert", "INSERT INTO items (title) VALUES ('a')", false},
+		{"update", "UPDATE items SET title = 'a'", false},
+		{"delete", "delete from items", false},
+		{"drop", "DROP TABLE items", false},
+		{"multiple statements", "SELECT 1; DELETE FROM items", false},
+		{"statement after comment", "SELECT 1; -- \n DROP TABLE items", false},
+		{"writing cte", "WITH d AS (DELETE FROM items RETURNING *) SELECT * FROM d", false},
+		{"select into table", "SELECT * INTO copy FROM items", false},
+		{"into outfile", "SELECT * FROM items INTO OUTFILE '/tmp/items'", false},
+		{"into dumpfile", "SELECT title FROM items LIMIT 1 INTO DUMPFILE '/tmp/title'", false},
+		{"for update", "SELECT * FROM items FOR UPDATE", false},
+		{"for share", "SELECT * FROM items FOR SHARE", false},
+		{"for no key update", "SELECT * FROM items FOR NO KEY UPDATE", false},
+		{"set", "SET search_path = public", false},
+		{"pragma", "PRAGMA writable_schema = ON", false},
+		{"nextval", "SELECT nextval('items_id_seq')", false},
+		{"setval", "SELECT setval('items_id_seq', 1)", false},
+		{"terminate backend", "SELECT pg_terminate_backend(pid) FROM pg_stat_activity", false},
+		{"qualified function", "SELECT pg_catalog.pg_sleep (10)", false},
+		{"lo import", "SELECT lo_import('/etc/passwd')", false},
+		{"read file", "SELECT pg_read_file('/etc/passwd')", false},
+		{"mysql sleep", "SELECT SLEEP(10)", false},
+		{"mysql load file", "SELECT LOAD_FILE('/etc/passwd')", false},
+		{"dblink", "SELECT * FROM dblink_exec('dbname=x', 'DROP TABLE items')", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateReadOnlySql(test.query)
+			if test.allowed && err != nil {
+				t.Errorf("query %q rejected: %v", test.query, err)
+			}
+			if !test.allowed && err == nil {
+				t.Errorf("query %q allowed", test.query)
+			}
+		})
+	}
+}
+
+func TestSqlReaderReadOnly(t *testing.T) {
+	conn, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "ds.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// single connection checks query_only is restored on pooled connection
+	pool, err := conn.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.SetMaxOpenConns(1)
+
+	if err := conn.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, title TEXT)").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	reader := sqlReader{conn: conn, query: "SELECT * FROM items"}
+
+	err = reader.readOnly(context.Background(), func(tx *gorm.DB) error {
+		return tx.Exec("INSERT INTO items (title) VALUES ('a')").Error
+	})
+	if err == nil {
+		t.Error("write in read only transaction succeeded")
+	}
+
+	rows, err := reader.Rows(RowsQuery{Limit: 10})
+	if err != nil || len(rows) != 0 {
+		t.Errorf("rows %v, error %v", rows, err)
+	}
+
+	if err := conn.Exec("INSERT INTO items (title) VALUES ('b')").Error; err != nil {
+		t.Errorf("connection left read only: %v", err)
+	}
+}
@@ -0,0 +1,368 @@
+package ds
+
+import (
+	"db-server/modules/rdb"
+	"db-server/server"
+	"db-server/server/db"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sync"
+	"time"
+)
+
+type SyncMode string
+
+const (
+	// SyncModeFull Copy all rows on each run
+	SyncModeFull SyncMode = "full"
+	// SyncModeIncremental Copy rows changed since last run
+	SyncModeIncremental SyncMode = "incremental"
+)
+
+// syncBatchSize Rows read from source by one query
+const syncBatchSize = 500
+
+// swagger:model
+type SyncJob struct {
+	// The sync job UUID
+	// example: 6204011c-30e6-408b-8aaa-dd8219860b4b
+	Id uuid.UUID `gorm:"primarykey" json:"id"`
+	// Sync job title
+	Title string `json:"title"`
+	// Source sql endpoint UUID
+	// example: 6234011c-30e6-408b-8aaa-dd8219860b4b
+	EndpointId uuid.UUID `json:"endpoint_id"`
+	// Target topic UUID
+	// example: 6204037c-30e6-408b-8aaa-dd8219860b4d
+	TopicId uuid.UUID `json:"topic_id"`
+	// Cron schedule
+	// example: */5 * * * *
+	TimeParams string `json:"time_params"`
+	// Sync mode, full or incremental
+	// example: incremental
+	Mode SyncMode `json:"mode"`
+	// Primary key column, detected from table when empty. Documents are upserted by it
+	// example: id
+	KeyColumn string `json:"key_column"`
+	// Change time column of incremental sync
+	// example: updated_at
+	UpdatedColumn string `json:"updated_column"`
+	// Max value of change time column synced by last run
+	LastValue string         `json:"last_value"`
+	CronId    cron.EntryID   `gorm:"index" json:"-"`
+	CreatedAt time.Time      `json:"-"`
+	UpdatedAt time.Time      `json:"-"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// TableName Gorm table name
+func (j SyncJob) TableName() string {
+	return "ds_sync"
+}
+
+func (j SyncJob) List(limit int, offset int, sort string, order string, filter map[string]string) ([]interface{}, error) {
+	var jobs []SyncJob
+
+	db.MetaDb.ListQuery(limit, offset, sort, order, filter, &jobs, make([]string, 0))
+
+	y := make([]interface{}, len(jobs))
+	for i, v := range jobs {
+		y[i] = v
+	}
+
+	return y, nil
+}
+
+func (j SyncJob) Total() *int64 {
+	return db.MetaDb.TotalRecords(&SyncJob{})
+}
+
+func (j SyncJob) GetById(id string) (interface{}, error) {
+	var job SyncJob
+
+	tx := db.MetaDb.GetConnection().First(&job, "id = ?", id)
+
+	if tx.RowsAffected < 1 {
+		return job, errors.New("no found")
+	}
+
+	return job, nil
+}
+
+func (j SyncJob) Delete(id string) {
+	conn := db.MetaDb.GetConnection()
+	conn.Where("id = ?", id).Delete(&j)
+}
+
+// swagger:model
+type SyncRun struct {
+	// The run UUID
+	// example: 6204011c-30e6-408b-8aaa-dd8219860b4b
+	Id uuid.UUID `gorm:"primarykey" json:"id"`
+	// Sync job UUID
+	// example: 6204011c-30e6-408b-8aaa-dd8219860b4b
+	SyncId uuid.UUID `gorm:"index" json:"sync_id"`
+	// Rows read from source
+	Rows int `json:"rows"`
+	// Documents inserted into topic
+	Inserted int64 `json:"inserted"`
+	// Documents changed in topic
+	Updated int64 `json:"updated"`
+	// Documents of rows deleted from source, removed in full mode
+	Deleted int64 `json:"deleted"`
+	// Run error
+	Error      string    `json:"error"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// TableName Gorm table name
+func (r SyncRun) TableName() string {
+	return "ds_sync_run"
+}
+
+// ListSyncRuns Runs of sync job
+func ListSyncRuns(syncId string, limit int, offset int, sort string, order string) []interface{} {
+	var runs []SyncRun
+
+	db.MetaDb.GetConnection().
+		Where("sync_id = ?", syncId).
+		Limit(limit).
+		Offset(offset).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: sort}, Desc: order != "ASC"}).
+		Find(&runs)
+
+	y := make([]interface{}, len(runs))
+	for i, v := range runs {
+		y[i] = v
+	}
+
+	return y
+}
+
+func (r SyncRun) GetById(id string) (interface{}, error) {
+	var run SyncRun
+
+	tx := db.MetaDb.GetConnection().First(&run, "id = ?", id)
+
+	if tx.RowsAffected < 1 {
+		return run, errors.New("no found")
+	}
+
+	return run, nil
+}
+
+func (r SyncRun) Delete(id string) {
+	conn := db.MetaDb.GetConnection()
+	conn.Where("id = ?", id).Delete(&r)
+}
+
+// runningSyncs Jobs in progress, next scheduled run of job is skipped while previous one works
+var runningSyncs = struct {
+	sync.Mutex
+	list map[uuid.UUID]bool
+}{list: make(map[uuid.UUID]bool)}
+
+// Schedule Add job to cron scheduler
+func (j SyncJob) Schedule(c *cron.Cron) {
+	var err error
+
+	j.CronId, err = c.AddFunc(j.TimeParams, func() {
+		log.Debug("Run sync " + j.Id.String())
+
+		job, err := SyncJob{}.GetById(j.Id.String())
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		job.(SyncJob).Run()
+	})
+
+	if err != nil {
+		log.Debug(err)
+	} else {
+		db.MetaDb.GetConnection().Model(&j).Update("cron_id", j.CronId)
+	}
+}
+
+// InitSync Schedule all sync jobs
+func InitSync() {
+	var jobs []SyncJob
+	db.MetaDb.GetConnection().Find(&jobs)
+
+	c := server.Cron.GetScheduler()
+	for _, job := range jobs {
+		log.Debug("Add sync job " + job.Id.String())
+		job.Schedule(c)
+	}
+}
+
+// Run Copy rows of endpoint to topic and save run statistics
+func (j SyncJob) Run() SyncRun {
+	run := SyncRun{SyncId: j.Id, StartedAt: time.Now()}
+	run.Id, _ = uuid.NewUUID()
+
+	runningSyncs.Lock()
+	if runningSyncs.list[j.Id] {
+		runningSyncs.Unlock()
+		run.Error = "previous run is in progress"
+		run.FinishedAt = time.Now()
+		return run
+	}
+	runningSyncs.list[j.Id] = true
+	runningSyncs.Unlock()
+
+	defer func() {
+		runningSyncs.Lock()
+		delete(runningSyncs.list, j.Id)
+		runningSyncs.Unlock()
+	}()
+
+	lastValue, err := j.copyRows(&run)
+	if err != nil {
+		log.Error(err)
+		run.Error = err.Error()
+	}
+
+	run.FinishedAt = time.Now()
+	conn := db.MetaDb.GetConnection()
+	conn.Create(&run)
+
+	if err == nil && lastValue != j.LastValue {
+		conn.Model(&j).Update("last_value", lastValue)
+	}
+
+	return run
+}
+
+func (j SyncJob) copyRows(run *SyncRun) (string, error) {
+	m, err := DataSourceEndpoint{}.GetById(j.EndpointId.String())
+	if err != nil {
+		return j.LastValue, errors.New("endpoint not found")
+	}
+	endpoint := m.(DataSourceEndpoint)
+
+	t, err := rdb.Rdb{}.GetById(j.TopicId.String())
+	if err != nil {
+		return j.LastValue, errors.New("topic not found")
+	}
+	topic := t.(rdb.Rdb)
+
+	reader, err := endpoint.getReader()
+	if err != nil {
+		return j.LastValue, err
+	}
+
+	source, ok := reader.(sqlReader)
+	if !ok {
+		return j.LastValue, errors.New("only sql endpoints can be synced")
+	}
+	// sync reads all rows of query
+	source.maxRows = 0
+
+	key := j.KeyColumn
+	if key == "" {
+		if key, err = source.primaryKey(); err != nil {
+			return j.LastValue, err
+		}
+	}
+
+	// keyset pagination, rows changed while sync runs do not shift pages
+	query := RowsQuery{Limit: syncBatchSize, Sort: key, Key: key, Order: "ASC"}
+	lastValue := j.LastValue
+
+	if j.Mode == SyncModeIncremental {
+		if j.UpdatedColumn == "" {
+			return lastValue, errors.New("updated column is required for incremental sync")
+		}
+
+		query.Sort = j.UpdatedColumn
+		if j.LastValue != "" {
+			// rows changed at the same time as last synced row are read again, unchanged documents are not updated
+			query.Filters = []RowsFilter{{Column: j.UpdatedColumn, Operator: "gte", Value: j.LastValue}}
+		}
+	}
+
+	// hidden and masked columns stay hidden in topic, key column is kept to match documents
+	project := endpoint.getProjection()
+	var seen []interface{}
+
+	for {
+		rows, err := source.Rows(query)
+		if err != nil {
+			return lastValue, err
+		}
+
+		for _, row := range rows {
+			doc := normalizeRow(project.applyRow(row))
+			doc[key] = normalizeValue(row[key])
+
+			res, err := server.UpsertTopicMessage(topic, key, doc)
+			if err != nil {
+				return lastValue, err
+			}
+
+			run.Rows++
+			run.Inserted += res.UpsertedCount
+			run.Updated += res.ModifiedCount
+
+			if j.Mode == SyncModeIncremental {
+				lastValue = syncValueString(normalizeValue(row[j.UpdatedColumn]))
+			} else {
+				seen = append(seen, doc[key])
+			}
+		}
+
+		if len(rows) < query.Limit {
+			break
+		}
+		query.After = normalizeRow(rows[len(rows)-1])
+	}
+
+	// full run read all rows, documents of other keys were deleted from source
+	if j.Mode != SyncModeIncremental {
+		if seen == nil {
+			seen = []interface{}{}
+		}
+
+		deleted, err := server.DeleteTopicMessagesExcept(topic, key, seen)
+		if err != nil {
+			return lastValue, err
+		}
+		run.Deleted = deleted
+	}
+
+	return lastValue, nil
+}
+
+// normalizeRow Convert sql driver values to values stored in mongo
+func normalizeRow(row map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(row))
+	for column, value := range row {
+		res[column] = normalizeValue(value)
+	}
+	return res
+}
+
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case *interface{}:
+		return normalizeValue(*v)
+	}
+	return value
+}
+
+// syncValueString Change time value in UTC with explicit offset, compared by databases regardless of session time zone
+func syncValueString(value interface{}) string {
+	if t, ok := value.(time.Time); ok {
+		return t.UTC().Format("2006-01-02 15:04:05.999999-07:00")
+	}
+	return valueString(value)
+}
@@ -0,0 +1,215 @@
+package ds
+
+import (
+	err2 "db-server/err"
+	"db-server/server"
+	"db-server/server/db"
+	"db-server/utils"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+func addSyncAdminRoutes(admin *mux.Router) {
+	admin.HandleFunc("/ds/sync", listSync).Methods(http.MethodGet, http.MethodOptions)               // each request calls PushHandler
+	admin.HandleFunc("/ds/sync", createSync).Methods(http.MethodPost, http.MethodOptions)            // each request calls PushHandler
+	admin.HandleFunc("/ds/sync/{id}", syncItem).Methods(http.MethodGet, http.MethodOptions)          // each request calls PushHandler
+	admin.HandleFunc("/ds/sync/{id}", deleteSync).Methods(http.MethodDelete, http.MethodOptions)     // each request calls PushHandler
+	admin.HandleFunc("/ds/sync/{id}", updateSync).Methods(http.MethodPut, http.MethodOptions)        // each request calls PushHandler
+	admin.HandleFunc("/ds/sync/{id}/run", runSync).Methods(http.MethodPost, http.MethodOptions)      // each request calls PushHandler
+	admin.HandleFunc("/ds/sync/{id}/runs", listSyncRuns).Methods(http.MethodGet, http.MethodOptions) // each request calls PushHandler
+}
+
+// listSync godoc
+// @Summary      List sync jobs
+// @Description  List scheduled copies of data source endpoints into topics
+// @Tags         Data source
+// @tags Admin
+// @Accept       json
+// @Produce      json
+// @Security bearerAuth
+// @Success      200  {array}   SyncJob
+//
+// @Router       /admin/ds/sync [get]
+func listSync(w http.ResponseWriter, r *http.Request) {
+	utils.ListItems(SyncJob{}, []string{"endpoint_id", "topic_id"}, r, w)
+}
+
+// createSync
+// @Summary      Create sync job
+// @Description  Create scheduled copy of sql endpoint rows into topic
+// @Tags         Data source
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        sync    body     SyncJob  true  "Sync job info" true
+// @Success      200 {object} SyncJob
+// @Security bearerAuth
+//
+// @Router       /admin/ds/sync [post]
+func createSync(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+	model := SyncJob{}
+
+	err := json.NewDecoder(r.Body).Decode(&model)
+	err2.DebugErr(err)
+	model.Id, err = uuid.NewUUID()
+	err2.DebugErr(err)
+
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if model.Mode == "" {
+		model.Mode = SyncModeFull
+	}
+
+	db.MetaDb.GetConnection().Create(&model)
+
+	model.Schedule(server.Cron.GetScheduler())
+
+	resp, _ := json.Marshal(model)
+	w.WriteHeader(200)
+	_, err = w.Write(resp)
+	err2.DebugErr(err)
+}
+
+// syncItem godoc
+// @Summary      Sync job info
+// @Description  Sync job detail info
+// @Tags         Data source
+// @tags Admin
+// @Accept       json
+// @Produce      json
+// @Param        id path    string  true  "Sync job id" id
+// @Security bearerAuth
+// @Success      200  {object}   SyncJob
+//
+// @Router       /admin/ds/sync/{id} [get]
+func syncItem(w http.ResponseWriter, r *http.Request) {
+	utils.GetItem(SyncJob{}, w, r)
+}
+
+// deleteSync godoc
+// @Summary      Delete sync job
+// @Description  Delete sync job
+// @Tags         Data source
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        id    path     string  true  "Sync job id" id
+// @Security bearerAuth
+// @Success      204
+//
+// @Router       /admin/ds/sync/{id} [delete]
+func deleteSync(w http.ResponseWriter, r *http.Request) {
+	if m, err := (SyncJob{}).GetById(mux.Vars(r)["id"]); err == nil {
+		server.Cron.GetScheduler().Remove(m.(SyncJob).CronId)
+	}
+
+	utils.DeleteItem(SyncJob{}, w, r)
+}
+
+// updateSync
+// @Summary      Update sync job
+// @Description  Update sync job
+// @Tags         Data source
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        sync    body     SyncJob  true  "Sync job info" true
+// @Param        id    path     string  true  "Sync job id"
+// @Success      200 {object} SyncJob
+// @Security bearerAuth
+//
+// @Router       /admin/ds/sync/{id} [put]
+func updateSync(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+	vars := mux.Vars(r)
+	m, err := SyncJob{}.GetById(vars["id"])
+
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	exist := m.(SyncJob)
+
+	newm := SyncJob{}
+
+	err = json.NewDecoder(r.Body).Decode(&newm)
+
+	newm.Id = exist.Id
+	newm.CreatedAt = exist.CreatedAt
+	db.MetaDb.GetConnection().Save(&newm)
+
+	c := server.Cron.GetScheduler()
+	c.Remove(exist.CronId)
+	newm.Schedule(c)
+
+	resp, _ := json.Marshal(newm)
+	w.WriteHeader(200)
+	_, err = w.Write(resp)
+	err2.DebugErr(err)
+}
+
+// runSync godoc
+// @Summary      Run sync job
+// @Description  Run sync job now and return run statistics
+// @Tags         Data source
+// @Tags         Admin
+// @Produce      json
+// @Param        id    path     string  true  "Sync job id" id
+// @Security bearerAuth
+// @Success      200  {object}   SyncRun
+//
+// @Router       /admin/ds/sync/{id}/run [post]
+func runSync(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	m, err := SyncJob{}.GetById(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	utils.SendResponse(w, 200, m.(SyncJob).Run(), nil)
+}
+
+// listSyncRuns godoc
+// @Summary      List sync job runs
+// @Description  List sync job runs statistics
+// @Tags         Data source
+// @tags Admin
+// @Accept       json
+// @Produce      json
+// @Param        id    path     string  true  "Sync job id" id
+// @Security bearerAuth
+// @Success      200  {array}   SyncRun
+//
+// @Router       /admin/ds/sync/{id}/runs [get]
+func listSyncRuns(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	l, o, or, so := utils.GetPagination(r)
+	if so == "id" {
+		so, or = "started_at", "DESC"
+	}
+
+	arr := ListSyncRuns(mux.Vars(r)["id"], l, o, so, or)
+
+	var total int64
+	db.MetaDb.GetConnection().Model(&SyncRun{}).Where("sync_id = ?", mux.Vars(r)["id"]).Count(&total)
+
+	w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("X-Total-Count", strconv.FormatInt(total, 10))
+
+	utils.SendResponse(w, 200, arr, nil)
+}
@@ -0,0 +1,224 @@
+package ds
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm/clause"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrReadOnly Endpoint has no write access
+	ErrReadOnly = errors.New("endpoint is read only")
+	// ErrRowNotFound Row with primary key not found
+	ErrRowNotFound = errors.New("row not found")
+)
+
+// InputError Invalid row payload
+type InputError struct {
+	Message string
+}
+
+func (e InputError) Error() string {
+	return e.Message
+}
+
+// tableWriter Write access to external sql table of endpoint
+type tableWriter struct {
+	source  string
+	reader  sqlReader
+	pk      string
+	columns map[string]bool
+	project projection
+}
+
+// getWriter Row access to endpoint table, row reads allowed on read only endpoints
+func (e DataSourceEndpoint) getWriter(write bool) (*tableWriter, error) {
+	if (write && !e.Writable) || e.Query != "" {
+		return nil, ErrReadOnly
+	}
+
+	reader, err := e.getReader()
+	if err != nil {
+		return nil, err
+	}
+
+	sql, ok := reader.(sqlReader)
+	if !ok {
+		return nil, errors.New("data source " + string(e.DataSource.Type) + " is not writable")
+	}
+
+	pk, err := sql.primaryKey()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &tableWriter{source: e.DataSource.Id.String(), reader: sql, pk: pk, columns: make(map[string]bool), project: e.getProjection()}
+	for _, column := range splitColumns(e.WritableColumns) {
+		w.columns[column] = true
+	}
+
+	return w, nil
+}
+
+// primaryKey Detect single column primary key of table
+func (r sqlReader) primaryKey() (string, error) {
+	columnTypes, err := r.conn.Migrator().ColumnTypes(r.table)
+	if err != nil {
+		return "", err
+	}
+
+	var keys []string
+	for _, ct := range columnTypes {
+		if isPk, ok := ct.PrimaryKey(); ok && isPk {
+			keys = append(keys, ct.Name())
+		}
+	}
+
+	if len(keys) != 1 {
+		return "", errors.New("table " + r.table + " must have single column primary key")
+	}
+
+	return keys[0], nil
+}
+
+// primaryKeys Primary keys of tables by connection pool and table, looked up once for default row order
+var primaryKeys sync.Map
+
+// cachedPrimaryKey Primary key of table, error is not cached
+func (r sqlReader) cachedPrimaryKey() (string, error) {
+	key := fmt.Sprintf("%p/%s", r.conn, r.table)
+	if pk, ok := primaryKeys.Load(key); ok {
+		return pk.(string), nil
+	}
+
+	pk, err := r.primaryKey()
+	if err != nil {
+		return "", err
+	}
+
+	primaryKeys.Store(key, pk)
+	return pk, nil
+}
+
+// values Check payload columns are writable
+func (w *tableWriter) values(payload map[string]interface{}) (map[string]interface{}, error) {
+	var denied []string
+	for column := range payload {
+		if !w.columns[column] {
+			denied = append(denied, column)
+		}
+	}
+
+	if len(denied) > 0 {
+		return nil, InputError{Message: "columns are not writable: " + strings.Join(denied, ", ")}
+	}
+
+	if len(payload) == 0 {
+		return nil, InputError{Message: "no columns to write"}
+	}
+
+	return payload, nil
+}
+
+// Get Projected row by primary key
+func (w *tableWriter) Get(pk string) (map[string]interface{}, error) {
+	row, err := w.get(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.project.applyRow(row), nil
+}
+
+func (w *tableWriter) get(pk string) (map[string]interface{}, error) {
+	rows := make([]map[string]interface{}, 0)
+
+	tx := w.reader.conn.Table(w.reader.table).
+		Where(clause.Eq{Column: clause.Column{Name: w.pk}, Value: pk}).
+		Limit(1).
+		Find(&rows)
+
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	if len(rows) == 0 {
+		return nil, ErrRowNotFound
+	}
+
+	return rows[0], nil
+}
+
+// Create Insert row, payload keys are output names of columns
+func (w *tableWriter) Create(payload map[string]interface{}) (map[string]interface{}, error) {
+	values, err := w.values(w.project.sourceRow(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	// postgres and sqlite return generated primary key into values, mysql ignores returning clause
+	tx := w.reader.conn.Table(w.reader.table).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: w.pk}}}).
+		Create(values)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	PurgeCache(w.source)
+
+	if pk, ok := values[w.pk]; ok {
+		return w.Get(valueString(pk))
+	}
+
+	// gorm sets mysql last insert id as @id
+	if pk, ok := values["@id"]; ok {
+		return w.Get(valueString(pk))
+	}
+
+	return w.project.applyRow(values), nil
+}
+
+// Update Update row by primary key, payload keys are output names of columns
+func (w *tableWriter) Update(pk string, payload map[string]interface{}) (map[string]interface{}, error) {
+	values, err := w.values(w.project.sourceRow(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.get(pk); err != nil {
+		return nil, err
+	}
+
+	tx := w.reader.conn.Table(w.reader.table).
+		Where(clause.Eq{Column: clause.Column{Name: w.pk}, Value: pk}).
+		Updates(values)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	PurgeCache(w.source)
+
+	if newPk, ok := values[w.pk]; ok {
+		pk = valueString(newPk)
+	}
+
+	return w.Get(pk)
+}
+
+// Delete Delete row by primary key
+func (w *tableWriter) Delete(pk string) error {
+	tx := w.reader.conn.Table(w.reader.table).
+		Where(clause.Eq{Column: clause.Column{Name: w.pk}, Value: pk}).
+		Delete(map[string]interface{}{})
+
+	if tx.Error != nil {
+		return tx.Error
+	}
+	PurgeCache(w.source)
+
+	if tx.RowsAffected < 1 {
+		return ErrRowNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package ds
+
+import (
+	err2 "db-server/err"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// xmlSelector XPath-like row selector.
+// "/a/b" matches b inside root a, "b", "a/b" and "//b" match on any depth, "*" matches any element
+type xmlSelector struct {
+	absolute bool
+	parts    []string
+}
+
+func parseXmlSelector(raw string) xmlSelector {
+	raw = strings.TrimSpace(raw)
+	s := xmlSelector{absolute: strings.HasPrefix(raw, "/") && !strings.HasPrefix(raw, "//")}
+
+	for _, part := range strings.Split(strings.Trim(raw, "/"), "/") {
+		if part != "" {
+			s.parts = append(s.parts, part)
+		}
+	}
+
+	return s
+}
+
+func (s xmlSelector) match(stack []string) bool {
+	if len(s.parts) == 0 || len(stack) < len(s.parts) || (s.absolute && len(stack) != len(s.parts)) {
+		return false
+	}
+
+	offset := len(stack) - len(s.parts)
+	for i, part := range s.parts {
+		if part != "*" && part != stack[offset+i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+type xmlReader struct {
+	endpoint DataSourceEndpoint
+}
+
+func (r xmlReader) Rows(query RowsQuery) ([]map[string]interface{}, error) {
+	rows, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return memoryRows(rows, query), nil
+}
+
+func (r xmlReader) Count(query RowsQuery) (int64, error) {
+	rows, err := r.load()
+	if err != nil {
+		return 0, err
+	}
+
+	return memoryCount(rows, query), nil
+}
+
+// Page Rows and total count from one file load
+func (r xmlReader) Page(query RowsQuery) ([]map[string]interface{}, int64, error) {
+	rows, err := r.load()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return memoryRows(rows, query), memoryCount(rows, query), nil
+}
+
+// load Parse all rows of xml file. Row attributes and child elements text are columns,
+// attributes of child elements are named child.attr, repeated children become arrays
+func (r xmlReader) load() ([]map[string]interface{}, error) {
+	dsn, err := r.endpoint.DataSource.dsn()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := openSourceFile(dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := file.Close()
+		err2.DebugErr(err)
+	}()
+
+	return parseXmlRows(file, parseXmlSelector(r.endpoint.RowSelector))
+}
+
+func parseXmlRows(file io.Reader, selector xmlSelector) ([]map[string]interface{}, error) {
+	rows := make([]map[string]interface{}, 0)
+	decoder := xml.NewDecoder(file)
+
+	var stack []string
+	var row map[string]interface{}
+	var rowDepth int
+	var field string
+	var text strings.Builder
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+
+			if row == nil && selector.match(stack) {
+				row = make(map[string]interface{})
+				rowDepth = len(stack)
+				for _, attr := range t.Attr {
+					setXmlColumn(row, attr.Name.Local, attr.Value)
+				}
+			} else if row != nil && len(stack) == rowDepth+1 {
+				field = t.Name.Local
+				text.Reset()
+				for _, attr := range t.Attr {
+					setXmlColumn(row, field+"."+attr.Name.Local, attr.Value)
+				}
+			}
+
+		case xml.CharData:
+			if row != nil && len(stack) > rowDepth {
+				text.Write(t)
+			}
+
+		case xml.EndElement:
+			if row != nil && len(stack) == rowDepth+1 {
+				setXmlColumn(row, field, strings.TrimSpace(text.String()))
+			}
+
+			if row != nil && len(stack) == rowDepth {
+				rows = append(rows, row)
+				row = nil
+			}
+
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	return rows, nil
+}
+
+func setXmlColumn(row map[string]interface{}, name string, value string) {
+	switch exist := row[name].(type) {
+	case nil:
+		row[name] = value
+	case []interface{}:
+		row[name] = append(exist, value)
+	default:
+		row[name] = []interface{}{exist, value}
+	}
+}
@@ -0,0 +1,37 @@
+package em
+
+import (
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net/http"
+)
+
+// parseDocumentId Convert path id to ObjectID if it looks like one
+func parseDocumentId(id string) interface{} {
+	if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+		return oid
+	}
+	return id
+}
+
+// toExtJSON Marshal document to relaxed Extended JSON, document id stays in "_id" field
+func toExtJSON(doc bson.D) (json.RawMessage, error) {
+	return bson.MarshalExtJSON(doc, false, false)
+}
+
+// readExtJSON Read Extended JSON document from request body
+func readExtJSON(r *http.Request) (bson.D, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON(body, false, &doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
@@ -3,18 +3,22 @@ package em
 import (
 	"db-server/drivers"
 	"db-server/events"
+	"db-server/modules/crypt"
+	"db-server/modules/hook"
 	"db-server/modules/project"
 	"db-server/modules/rdb"
 	"db-server/server"
 	"db-server/utils"
-	"fmt"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 )
@@ -29,8 +33,13 @@ func AddPublicApiRoutes(em *mux.Router) {
 }
 
 func AddAdminRoutes(admin *mux.Router) {
-	admin.HandleFunc("/topics/{topic}/data", topicData).Methods(http.MethodGet, http.MethodOptions) // each request calls push
-	admin.HandleFunc("/em/list/{topic}", adminList).Methods(http.MethodGet, http.MethodOptions)     // each request calls push
+	admin.HandleFunc("/topics/{topic}/data", topicData).Methods(http.MethodGet, http.MethodOptions)               // each request calls push
+	admin.HandleFunc("/topics/{topic}/data", topicDataCreate).Methods(http.MethodPost, http.MethodOptions)        // each request calls push
+	admin.HandleFunc("/topics/{topic}/data/{id}", topicDataItem).Methods(http.MethodGet, http.MethodOptions)      // each request calls push
+	admin.HandleFunc("/topics/{topic}/data/{id}", topicDataReplace).Methods(http.MethodPut, http.MethodOptions)   // each request calls push
+	admin.HandleFunc("/topics/{topic}/data/{id}", topicDataDelete).Methods(http.MethodDelete, http.MethodOptions) // each request calls push
+	admin.HandleFunc("/topics/{topic}/schema", topicSchema).Methods(http.MethodGet, http.MethodOptions)           // each request calls push
+	admin.HandleFunc("/em/list/{topic}", adminList).Methods(http.MethodGet, http.MethodOptions)                   // each request calls push
 }
 
 func getTopic(r *http.Request) string {
@@ -38,37 +47,62 @@ func getTopic(r *http.Request) string {
 	return vars["topic"]
 }
 
-func checkAccess(w http.ResponseWriter, r *http.Request) bool {
-	topic := getTopic(r)
+// getProjectTopic Find topic of project with key
+func getProjectTopic(topic string, key string) (rdb.Rdb, bool) {
+	p, err := project.Project{}.GetByKey(key)
+	if err != nil || !utils.ValidateKey(p.Key, key) {
+		return rdb.Rdb{}, false
+	}
+
+	dbi := rdb.Rdb{}.GetByProjectCollection(p.Id, topic)
 
-	dbi := rdb.Rdb{}.GetByCollection(topic)
+	return dbi, dbi.Id != uuid.Nil
+}
 
-	p := dbi.Project
+// getAdminTopic Find topic for admin api, project_id query param selects project when topic name is ambiguous
+func getAdminTopic(w http.ResponseWriter, r *http.Request) (rdb.Rdb, bool) {
+	topic := getTopic(r)
 
-	if !validateOrigin(p, r.Header.Get("Origin")) {
-		utils.Send403Error(w, "Cors error. Origin not allowed")
-		return false
+	var dbi rdb.Rdb
+	if projectId, err := uuid.Parse(r.URL.Query().Get("project_id")); err == nil {
+		dbi = rdb.Rdb{}.GetByProjectCollection(projectId, topic)
+	} else {
+		dbi = rdb.Rdb{}.GetByCollection(topic)
 	}
 
-	if !utils.ValidateKey(p.Key, r.Header.Get("db-key")) {
-		utils.Send403Error(w, "db-key not Valid")
-		return false
+	if dbi.Id == uuid.Nil {
+		w.WriteHeader(404)
+		return dbi, false
 	}
 
-	return true
+	return dbi, true
 }
 
-func validateOrigin(p project.Project, origin string) bool {
-	pOrigins := strings.Split(p.Origins, ";")
-	for _, pOrigin := range pOrigins {
-		if pOrigin == "*" || pOrigin == origin {
-			return true
-		}
+func checkAccess(w http.ResponseWriter, r *http.Request) (rdb.Rdb, bool) {
+	dbi, ok := getProjectTopic(getTopic(r), r.Header.Get("db-key"))
+
+	if !ok {
+		utils.Send403Error(w, "db-key not Valid")
+		return dbi, false
 	}
 
-	log.Debug("Invalid origin")
+	if !dbi.Project.ValidateOrigin(r.Header.Get("Origin")) {
+		utils.Send403Error(w, "Cors error. Origin not allowed")
+		return dbi, false
+	}
+
+	return dbi, true
+}
+
+func sendHookError(w http.ResponseWriter, err error) {
+	var rejectErr hook.RejectError
+	if errors.As(err, &rejectErr) {
+		payload := map[string]string{"code": "rejected", "message": rejectErr.Message}
+		utils.SendResponse(w, 422, payload, nil)
+		return
+	}
 
-	return false
+	utils.SendResponse(w, 500, nil, err)
 }
 
 // push godoc
@@ -85,11 +119,18 @@ func push(w http.ResponseWriter, r *http.Request) {
 
 	log.Debug(r.Method, r.RequestURI)
 
-	topic := getTopic(r)
+	if dbi, ok := checkAccess(w, r); ok {
+		requestPayload, err := hook.RunBefore(dbi, hook.CreateEvent, "", utils.GetPayload(r))
+		if err != nil {
+			sendHookError(w, err)
+			return
+		}
+
+		err = server.SaveTopicMessage(dbi, requestPayload)
+		if err == nil {
+			go hook.RunAfter(dbi, hook.CreateEvent, "", requestPayload)
+		}
 
-	if checkAccess(w, r) {
-		requestPayload := utils.GetPayload(r)
-		err := server.SaveTopicMessage(os.Getenv("DB_NAME"), topic, requestPayload)
 		var i interface{}
 		utils.SendResponse(w, 202, i, err)
 	}
@@ -115,22 +156,18 @@ var upgrader = websocket.Upgrader{
 func subscribe(w http.ResponseWriter, r *http.Request) {
 	log.Debug(r.Method, r.RequestURI)
 
-	topic := getTopic(r)
-
 	vars := mux.Vars(r)
 	rkey := vars["key"]
 
-	dbi := rdb.Rdb{}.GetByCollection(topic)
-
-	p := dbi.Project
+	dbi, ok := getProjectTopic(getTopic(r), rkey)
 
-	if !utils.ValidateKey(p.Key, rkey) {
+	if !ok {
 		utils.Send403Error(w, "db-key not Valid")
 	} else {
 		c, err := upgrader.Upgrade(w, r, nil)
 
-		events.GetInstance().Subscribe(topic, c)
-		defer events.GetInstance().Unsubscribe(topic, c)
+		events.GetInstance().Subscribe(dbi.Namespace(), c)
+		defer events.GetInstance().Unsubscribe(dbi.Namespace(), c)
 
 		err = c.WriteMessage(1, []byte("test own message"))
 
@@ -168,15 +205,20 @@ func find(w http.ResponseWriter, r *http.Request) {
 
 	log.Debug(r.Method, r.RequestURI)
 
-	topic := getTopic(r)
 	requestPayload := utils.GetPayload(r)
 
-	if checkAccess(w, r) {
+	if dbi, ok := checkAccess(w, r); ok {
 		limit, offset, _, _ := utils.GetPagination(r)
 
-		res, err := drivers.GetDbInstance().Find(os.Getenv("DB_NAME"), topic, requestPayload, int64(limit), int64(offset))
+		filter, err := crypt.EncryptFilter(dbi, requestPayload)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
 
-		utils.SendResponse(w, 200, res, err)
+		res, err := drivers.GetDbInstance().Find(dbi.GetDbName(), dbi.Collection, filter, int64(limit), int64(offset))
+
+		utils.SendResponse(w, 200, crypt.DecryptDocuments(dbi, res), err)
 	}
 }
 
@@ -211,9 +253,7 @@ func list(w http.ResponseWriter, r *http.Request) {
 
 	log.Debug(r.Method, r.RequestURI)
 
-	topic := getTopic(r)
-
-	if checkAccess(w, r) {
+	if dbi, ok := checkAccess(w, r); ok {
 
 		limit, offset, rorder, sort := utils.GetPagination(r)
 
@@ -234,11 +274,17 @@ func list(w http.ResponseWriter, r *http.Request) {
 
 		order, sort := drivers.GetMongoSort(sort, rorder)
 
-		res, count, err := drivers.GetDbInstance().List(os.Getenv("DB_NAME"), topic, int64(limit), int64(offset), order, sort, filter)
+		encFilter, err := crypt.EncryptFilter(dbi, filter)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		res, count, err := drivers.GetDbInstance().List(dbi.GetDbName(), dbi.Collection, int64(limit), int64(offset), order, sort, encFilter.(bson.D))
 
 		w.Header().Add("X-Total-Count", strconv.FormatInt(count, 10))
 
-		utils.SendResponse(w, 200, res, err)
+		utils.SendResponse(w, 200, crypt.DecryptDocuments(dbi, res), err)
 	}
 }
 
@@ -258,7 +304,10 @@ func adminList(w http.ResponseWriter, r *http.Request) {
 
 	log.Debug(r.Method, r.RequestURI)
 
-	topic := getTopic(r)
+	dbi, ok := getAdminTopic(w, r)
+	if !ok {
+		return
+	}
 
 	limit, offset, rorder, sort := utils.GetPagination(r)
 
@@ -279,11 +328,17 @@ func adminList(w http.ResponseWriter, r *http.Request) {
 
 	order, sort := drivers.GetMongoSort(sort, rorder)
 
-	res, count, err := drivers.GetDbInstance().List(os.Getenv("DB_NAME"), topic, int64(limit), int64(offset), order, sort, filter)
+	encFilter, err := crypt.EncryptFilter(dbi, filter)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	res, count, err := drivers.GetDbInstance().List(dbi.GetDbName(), dbi.Collection, int64(limit), int64(offset), order, sort, encFilter.(bson.D))
 
 	w.Header().Add("X-Total-Count", strconv.FormatInt(count, 10))
 
-	utils.SendResponse(w, 200, res, err)
+	utils.SendResponse(w, 200, crypt.DecryptDocuments(dbi, res), err)
 }
 
 // update godoc
@@ -301,16 +356,27 @@ func update(w http.ResponseWriter, r *http.Request) {
 
 	log.Debug(r.Method, r.RequestURI)
 
-	topic := getTopic(r)
-
-	if checkAccess(w, r) {
-
-		requestPayload := utils.GetPayload(r)
+	if dbi, ok := checkAccess(w, r); ok {
 
 		vars := mux.Vars(r)
 		id := vars["id"]
 
-		res, err := drivers.GetDbInstance().Update(os.Getenv("DB_NAME"), topic, id, requestPayload)
+		requestPayload, err := hook.RunBefore(dbi, hook.UpdateEvent, id, utils.GetPayload(r))
+		if err != nil {
+			sendHookError(w, err)
+			return
+		}
+
+		stored, err := crypt.EncryptUpdate(dbi, requestPayload)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		res, err := drivers.GetDbInstance().Update(dbi.GetDbName(), dbi.Collection, id, stored)
+		if err == nil {
+			go hook.RunAfter(dbi, hook.UpdateEvent, id, requestPayload)
+		}
 
 		utils.SendResponse(w, 202, res, err)
 	}
@@ -331,13 +397,19 @@ func deleteItem(w http.ResponseWriter, r *http.Request) {
 
 	log.Debug(r.Method, r.RequestURI)
 
-	topic := getTopic(r)
-
-	if checkAccess(w, r) {
+	if dbi, ok := checkAccess(w, r); ok {
 		vars := mux.Vars(r)
 		id := vars["id"]
 
-		res, err := drivers.GetDbInstance().Delete(os.Getenv("DB_NAME"), topic, id)
+		if _, err := hook.RunBefore(dbi, hook.DeleteEvent, id, nil); err != nil {
+			sendHookError(w, err)
+			return
+		}
+
+		res, err := drivers.GetDbInstance().Delete(dbi.GetDbName(), dbi.Collection, id)
+		if err == nil {
+			go hook.RunAfter(dbi, hook.DeleteEvent, id, nil)
+		}
 
 		utils.SendResponse(w, 202, res, err)
 	}
@@ -345,7 +417,7 @@ func deleteItem(w http.ResponseWriter, r *http.Request) {
 
 // topicData godoc
 // @Summary      Topic output data
-// @Description  topic data
+// @Description  topic data in relaxed Extended JSON
 // @Tags         Entity manager
 // @tags Admin
 // @Accept       json
@@ -358,7 +430,10 @@ func deleteItem(w http.ResponseWriter, r *http.Request) {
 func topicData(w http.ResponseWriter, r *http.Request) {
 	log.Debug(r.Method, r.RequestURI)
 
-	topic := getTopic(r)
+	dbi, ok := getAdminTopic(w, r)
+	if !ok {
+		return
+	}
 
 	limit, offset, rorder, sort := utils.GetPagination(r)
 
@@ -366,19 +441,15 @@ func topicData(w http.ResponseWriter, r *http.Request) {
 
 	log.Debug("Mongo limit " + strconv.Itoa(limit) + " offset " + strconv.Itoa(offset) + " order " + rorder + " sort " + sort)
 
-	res, count, err := drivers.GetDbInstance().List(os.Getenv("DB_NAME"), topic, int64(limit), int64(offset), order, sort, bson.D{})
-
-	var result []map[string]string
+	res, count, err := drivers.GetDbInstance().List(dbi.GetDbName(), dbi.Collection, int64(limit), int64(offset), order, sort, bson.D{})
 
-	for _, resArray := range res {
-		record := make(map[string]string)
-		for key, obj := range resArray.Map() {
+	result := make([]json.RawMessage, 0, len(res))
 
-			if key == "_id" {
-				key = "id"
-				obj = strings.ReplaceAll(strings.ReplaceAll(fmt.Sprintf("%v", obj), "bjectID(\"", ""), "\")", "")
-			}
-			record[key] = fmt.Sprintf("%v", obj)
+	for _, doc := range crypt.DecryptDocuments(dbi, res) {
+		record, mErr := toExtJSON(*doc)
+		if mErr != nil {
+			err = mErr
+			break
 		}
 		result = append(result, record)
 	}
@@ -387,3 +458,216 @@ func topicData(w http.ResponseWriter, r *http.Request) {
 
 	utils.SendResponse(w, 200, result, err)
 }
+
+// topicDataItem godoc
+// @Summary      Topic record
+// @Description  Topic record in relaxed Extended JSON
+// @Tags         Entity manager
+// @tags Admin
+// @Accept       json
+// @Produce      json
+// @Param        topic path    string  true  "Topic name"
+// @Param        id path    string  true  "Record id"
+// @Security bearerAuth
+// @Success      200  {object} object
+//
+// @Router       /admin/topics/{topic}/data/{id} [get]
+func topicDataItem(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	dbi, ok := getAdminTopic(w, r)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+
+	doc, err := drivers.GetDbInstance().Get(dbi.GetDbName(), dbi.Collection, parseDocumentId(vars["id"]))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		w.WriteHeader(404)
+		return
+	}
+
+	sendExtJSON(w, 200, crypt.DecryptDocument(dbi, doc), err)
+}
+
+// topicDataCreate godoc
+// @Summary      Create topic record
+// @Description  Create topic record from Extended JSON
+// @Tags         Entity manager
+// @tags Admin
+// @Accept       json
+// @Produce      json
+// @Param        topic path    string  true  "Topic name"
+// @Security bearerAuth
+// @Success      201  {object} object
+//
+// @Router       /admin/topics/{topic}/data [post]
+func topicDataCreate(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	dbi, ok := getAdminTopic(w, r)
+	if !ok {
+		return
+	}
+
+	doc, err := readExtJSON(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	stored, err := crypt.EncryptDocument(dbi, doc)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	res, err := drivers.GetDbInstance().Insert(dbi.GetDbName(), dbi.Collection, stored)
+	if err != nil {
+		utils.SendResponse(w, 500, nil, err)
+		return
+	}
+
+	events.GetInstance().RegisterNewMessage(dbi.Namespace(), doc)
+
+	doc, err = drivers.GetDbInstance().Get(dbi.GetDbName(), dbi.Collection, res.InsertedID)
+
+	sendExtJSON(w, 201, crypt.DecryptDocument(dbi, doc), err)
+}
+
+// topicDataReplace godoc
+// @Summary      Replace topic record
+// @Description  Replace topic record with Extended JSON document
+// @Tags         Entity manager
+// @tags Admin
+// @Accept       json
+// @Produce      json
+// @Param        topic path    string  true  "Topic name"
+// @Param        id path    string  true  "Record id"
+// @Security bearerAuth
+// @Success      200  {object} object
+//
+// @Router       /admin/topics/{topic}/data/{id} [put]
+func topicDataReplace(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	dbi, ok := getAdminTopic(w, r)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	id := parseDocumentId(vars["id"])
+
+	doc, err := readExtJSON(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	replacement := bson.D{}
+	for _, e := range doc {
+		if e.Key != "_id" {
+			replacement = append(replacement, e)
+		}
+	}
+
+	stored, err := crypt.EncryptDocument(dbi, replacement)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	res, err := drivers.GetDbInstance().Replace(dbi.GetDbName(), dbi.Collection, id, stored)
+	if err != nil {
+		utils.SendResponse(w, 500, nil, err)
+		return
+	}
+
+	if res.MatchedCount < 1 {
+		w.WriteHeader(404)
+		return
+	}
+
+	doc, err = drivers.GetDbInstance().Get(dbi.GetDbName(), dbi.Collection, id)
+
+	sendExtJSON(w, 200, crypt.DecryptDocument(dbi, doc), err)
+}
+
+// topicDataDelete godoc
+// @Summary      Delete topic record
+// @Description  Delete topic record
+// @Tags         Entity manager
+// @tags Admin
+// @Accept       json
+// @Produce      json
+// @Param        topic path    string  true  "Topic name"
+// @Param        id path    string  true  "Record id"
+// @Security bearerAuth
+// @Success      204
+//
+// @Router       /admin/topics/{topic}/data/{id} [delete]
+func topicDataDelete(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	dbi, ok := getAdminTopic(w, r)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+
+	res, err := drivers.GetDbInstance().Delete(dbi.GetDbName(), dbi.Collection, parseDocumentId(vars["id"]))
+	if err != nil {
+		utils.SendResponse(w, 500, nil, err)
+		return
+	}
+
+	if res.DeletedCount < 1 {
+		w.WriteHeader(404)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func sendExtJSON(w http.ResponseWriter, statusCode int, doc bson.D, err error) {
+	var record json.RawMessage
+	if err == nil {
+		record, err = toExtJSON(doc)
+	}
+
+	utils.SendResponse(w, statusCode, record, err)
+}
+
+// topicSchema godoc
+// @Summary      Topic schema
+// @Description  Infer topic fields from documents sample and show collection statistics
+// @Tags         Entity manager
+// @tags Admin
+// @Accept       json
+// @Produce      json
+// @Param        topic path    string  true  "Topic name"
+// @Param        size query    int  false  "Sample size, default 100, max 1000"
+// @Security bearerAuth
+// @Success      200  {object} em.TopicSchema
+//
+// @Router       /admin/topics/{topic}/schema [get]
+func topicSchema(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+
+	dbi, ok := getAdminTopic(w, r)
+	if !ok {
+		return
+	}
+
+	size, err := strconv.Atoi(r.URL.Query().Get("size"))
+	if err != nil || size <= 0 {
+		size = defaultSampleSize
+	}
+	if size > maxSampleSize {
+		size = maxSampleSize
+	}
+
+	res, err := inferSchema(dbi.GetDbName(), dbi.Collection, size)
+
+	utils.SendResponse(w, 200, res, err)
+}
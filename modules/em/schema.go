@@ -0,0 +1,223 @@
+package em
+
+import (
+	"db-server/drivers"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"time"
+)
+
+const (
+	defaultSampleSize = 100
+	maxSampleSize     = 1000
+	maxFieldExamples  = 3
+)
+
+// TopicSchema Inferred topic structure and collection statistics
+type TopicSchema struct {
+	// Topic name
+	Topic string `json:"topic"`
+	// Documents in collection
+	Count int64 `json:"count"`
+	// Uncompressed data size in bytes
+	Size int64 `json:"size"`
+	// Allocated storage size in bytes
+	StorageSize int64 `json:"storage_size"`
+	// Total indexes size in bytes
+	IndexSize int64 `json:"index_size"`
+	// Documents used for inference
+	SampleSize int `json:"sample_size"`
+	// Field paths found in sample
+	Fields []*FieldSchema `json:"fields"`
+	// Index usage
+	Indexes []IndexUsage `json:"indexes"`
+}
+
+// FieldSchema Inferred field info. Array items path ends with []
+type FieldSchema struct {
+	// Field path
+	// example: address.city
+	Path string `json:"path"`
+	// Value type occurrences
+	Types map[string]int `json:"types"`
+	// Share of sampled documents without value
+	NullRate float64 `json:"null_rate"`
+	// Example values
+	Examples []interface{} `json:"examples"`
+
+	present int
+}
+
+// IndexUsage Index info from $indexStats
+type IndexUsage struct {
+	Name  string    `json:"name"`
+	Key   bson.M    `json:"key"`
+	Size  int64     `json:"size"`
+	Ops   int64     `json:"ops"`
+	Since time.Time `json:"since"`
+}
+
+type collStats struct {
+	StorageStats struct {
+		Count          int64            `bson:"count"`
+		Size           int64            `bson:"size"`
+		StorageSize    int64            `bson:"storageSize"`
+		TotalIndexSize int64            `bson:"totalIndexSize"`
+		IndexSizes     map[string]int64 `bson:"indexSizes"`
+	} `bson:"storageStats"`
+}
+
+type indexStats struct {
+	Name     string `bson:"name"`
+	Key      bson.M `bson:"key"`
+	Accesses struct {
+		Ops   int64     `bson:"ops"`
+		Since time.Time `bson:"since"`
+	} `bson:"accesses"`
+}
+
+// inferSchema Build topic schema from random sample and mongo statistics
+func inferSchema(dbName string, topic string, sampleSize int) (TopicSchema, error) {
+	schema := TopicSchema{Topic: topic, Fields: []*FieldSchema{}, Indexes: []IndexUsage{}}
+
+	var sample []bson.D
+	err := drivers.GetDbInstance().Aggregate(dbName, topic, bson.A{bson.D{{Key: "$sample", Value: bson.D{{Key: "size", Value: sampleSize}}}}}, &sample)
+	if err != nil {
+		return schema, err
+	}
+
+	fields := make(map[string]*FieldSchema)
+	for _, doc := range sample {
+		seen := make(map[string]bool)
+		walkDocument("", doc, fields, seen)
+		for path := range seen {
+			fields[path].present++
+		}
+	}
+
+	schema.SampleSize = len(sample)
+	for _, f := range fields {
+		if schema.SampleSize > 0 {
+			f.NullRate = 1 - float64(f.present)/float64(schema.SampleSize)
+		}
+		schema.Fields = append(schema.Fields, f)
+	}
+	sort.Slice(schema.Fields, func(i, j int) bool { return schema.Fields[i].Path < schema.Fields[j].Path })
+
+	var stats []collStats
+	err = drivers.GetDbInstance().Aggregate(dbName, topic, bson.A{bson.D{{Key: "$collStats", Value: bson.D{{Key: "storageStats", Value: bson.D{}}}}}}, &stats)
+	if err != nil {
+		return schema, err
+	}
+
+	var sizes map[string]int64
+	if len(stats) > 0 {
+		schema.Count = stats[0].StorageStats.Count
+		schema.Size = stats[0].StorageStats.Size
+		schema.StorageSize = stats[0].StorageStats.StorageSize
+		schema.IndexSize = stats[0].StorageStats.TotalIndexSize
+		sizes = stats[0].StorageStats.IndexSizes
+	}
+
+	var indexes []indexStats
+	err = drivers.GetDbInstance().Aggregate(dbName, topic, bson.A{bson.D{{Key: "$indexStats", Value: bson.D{}}}}, &indexes)
+	if err != nil {
+		return schema, err
+	}
+
+	for _, index := range indexes {
+		schema.Indexes = append(schema.Indexes, IndexUsage{
+			Name:  index.Name,
+			Key:   index.Key,
+			Size:  sizes[index.Name],
+			Ops:   index.Accesses.Ops,
+			Since: index.Accesses.Since,
+		})
+	}
+
+	return schema, nil
+}
+
+func walkDocument(prefix string, doc bson.D, fields map[string]*FieldSchema, seen map[string]bool) {
+	for _, e := range doc {
+		path := e.Key
+		if prefix != "" {
+			path = prefix + "." + e.Key
+		}
+		walkValue(path, e.Value, fields, seen)
+	}
+}
+
+func walkValue(path string, value interface{}, fields map[string]*FieldSchema, seen map[string]bool) {
+	f, ok := fields[path]
+	if !ok {
+		f = &FieldSchema{Path: path, Types: make(map[string]int), Examples: []interface{}{}}
+		fields[path] = f
+	}
+
+	t := bsonTypeName(value)
+	f.Types[t]++
+
+	if t == "null" {
+		return
+	}
+
+	seen[path] = true
+
+	switch v := value.(type) {
+	case bson.D:
+		walkDocument(path, v, fields, seen)
+	case bson.A:
+		for _, item := range v {
+			walkValue(path+"[]", item, fields, seen)
+		}
+	default:
+		if len(f.Examples) < maxFieldExamples && !hasExample(f.Examples, v) {
+			f.Examples = append(f.Examples, v)
+		}
+	}
+}
+
+func hasExample(examples []interface{}, value interface{}) bool {
+	for _, e := range examples {
+		if fmt.Sprintf("%v", e) == fmt.Sprintf("%v", value) {
+			return true
+		}
+	}
+	return false
+}
+
+func bsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int32, int64:
+		return "int"
+	case float64:
+		return "double"
+	case primitive.Decimal128:
+		return "decimal"
+	case primitive.ObjectID:
+		return "objectId"
+	case primitive.DateTime, time.Time:
+		return "date"
+	case primitive.Timestamp:
+		return "timestamp"
+	case primitive.Binary:
+		return "binary"
+	case primitive.Regex:
+		return "regex"
+	case bson.D, bson.M:
+		return "object"
+	case bson.A:
+		return "array"
+	}
+
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,241 @@
+package hook
+
+import (
+	"db-server/modules/cf"
+	"db-server/modules/plugin"
+	"db-server/modules/rdb"
+	"db-server/server/db"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+type HookEvent string
+
+const (
+	CreateEvent HookEvent = "create"
+	UpdateEvent HookEvent = "update"
+	DeleteEvent HookEvent = "delete"
+)
+
+type HookStage string
+
+const (
+	BeforeStage HookStage = "before"
+	AfterStage  HookStage = "after"
+)
+
+type HookHandler string
+
+const (
+	FunctionHandler HookHandler = "func"
+	PluginHandler   HookHandler = "plugin"
+)
+
+// Default hook timeout in seconds
+const defaultTimeout = 5
+
+// swagger:model
+type Hook struct {
+	// The hook UUID
+	// example: 6204037c-30e6-418b-8aaa-dd8219860b4c
+	Id uuid.UUID `gorm:"primarykey" json:"id"`
+	// Mnemonic name
+	Title string `json:"title"`
+	// The topic (rdb) UUID
+	// example: 6204037c-30e6-418b-8saa-dd8219860b4c
+	RdbId uuid.UUID `gorm:"index" json:"rdb_id"`
+	// Topic event
+	// Enum of HookEvent
+	// example: create
+	Event HookEvent `json:"event"`
+	// Run before or after write
+	// Enum of HookStage
+	// example: before
+	Stage HookStage `json:"stage"`
+	// Handler type
+	// Enum of HookHandler
+	// example: func
+	Handler HookHandler `json:"handler"`
+	// The cloud function or plugin UUID
+	// example: 6204037c-30e6-413b-8saa-dd8219860b4c
+	HandlerId uuid.UUID `json:"handler_id"`
+	// Handler timeout in seconds
+	// example: 5
+	Timeout   int            `json:"timeout"`
+	CreatedAt time.Time      `json:"-"`
+	UpdatedAt time.Time      `json:"-"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// TableName Gorm table name
+func (h Hook) TableName() string {
+	return "hook"
+}
+
+// HookRequest Data passed to hook handler
+type HookRequest struct {
+	Topic    string                 `json:"topic"`
+	Event    HookEvent              `json:"event"`
+	Id       string                 `json:"id,omitempty"`
+	Document map[string]interface{} `json:"document,omitempty"`
+}
+
+// HookResult Data returned by hook handler. Empty result allows write as is
+type HookResult struct {
+	// Reject write
+	Reject bool `json:"reject"`
+	// Reject reason
+	Message string `json:"message"`
+	// Modified document, replaces proposed one
+	Document map[string]interface{} `json:"document"`
+}
+
+// RejectError Write rejected by before hook
+type RejectError struct {
+	Message string
+}
+
+func (e RejectError) Error() string {
+	return e.Message
+}
+
+func (h Hook) List(limit int, offset int, sort string, order string, filter map[string]string) ([]interface{}, error) {
+	var hooks []Hook
+
+	db.MetaDb.ListQuery(limit, offset, sort, order, filter, &hooks, make([]string, 0))
+
+	y := make([]interface{}, len(hooks))
+	for i, v := range hooks {
+		y[i] = v
+	}
+
+	return y, nil
+}
+
+func (h Hook) GetById(id string) (interface{}, error) {
+	var hook Hook
+	conn := db.MetaDb.GetConnection()
+	tx := conn.First(&hook, "id = ?", id)
+
+	if tx.RowsAffected < 1 {
+		return hook, errors.New("no found")
+	}
+
+	return hook, nil
+}
+
+func (h Hook) Delete(id string) {
+	conn := db.MetaDb.GetConnection()
+	conn.Where("id = ?", id).Delete(&h)
+}
+
+func (h Hook) Total() *int64 {
+	return db.MetaDb.TotalRecords(&Hook{})
+}
+
+func (h Hook) getTimeout() time.Duration {
+	if h.Timeout <= 0 {
+		return defaultTimeout * time.Second
+	}
+	return time.Duration(h.Timeout) * time.Second
+}
+
+// Call Run hook handler with request and wait result up to hook timeout
+func (h Hook) Call(request HookRequest) (HookResult, error) {
+	var result HookResult
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return result, err
+	}
+
+	switch h.Handler {
+	case FunctionHandler:
+		f, err := cf.CloudFunction{}.GetById(h.HandlerId.String())
+		if err != nil {
+			return result, err
+		}
+
+		out, err := f.(cf.CloudFunction).Call(payload, h.getTimeout())
+		if err != nil || out == "" {
+			return result, err
+		}
+
+		err = json.Unmarshal([]byte(out), &result)
+		return result, err
+
+	case PluginHandler:
+		p, err := plugin.Plugin{}.GetById(h.HandlerId.String())
+		if err != nil {
+			return result, err
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			res := p.(plugin.Plugin).Run(request)
+			if res.Err != nil || res.Payload == nil {
+				done <- res.Err
+				return
+			}
+			out, err := json.Marshal(res.Payload)
+			if err == nil {
+				err = json.Unmarshal(out, &result)
+			}
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			return result, err
+		case <-time.After(h.getTimeout()):
+			return HookResult{}, errors.New("hook " + h.Id.String() + " timeout")
+		}
+	}
+
+	return result, errors.New("unknown hook handler " + string(h.Handler))
+}
+
+func findHooks(topic rdb.Rdb, event HookEvent, stage HookStage) []Hook {
+	var hooks []Hook
+
+	conn := db.MetaDb.GetConnection()
+	conn.Order("created_at ASC").Find(&hooks, "rdb_id = ? AND event = ? AND stage = ?", topic.Id, event, stage)
+
+	return hooks
+}
+
+// RunBefore Run before hooks of topic event one by one.
+// Each hook receives document returned by previous one, first rejection stops the chain
+func RunBefore(topic rdb.Rdb, event HookEvent, id string, document map[string]interface{}) (map[string]interface{}, error) {
+	for _, h := range findHooks(topic, event, BeforeStage) {
+		res, err := h.Call(HookRequest{Topic: topic.Collection, Event: event, Id: id, Document: document})
+		if err != nil {
+			return document, fmt.Errorf("hook %s failed: %w", h.Id.String(), err)
+		}
+
+		if res.Reject {
+			return document, RejectError{Message: res.Message}
+		}
+
+		if res.Document != nil {
+			document = res.Document
+		}
+	}
+
+	return document, nil
+}
+
+// RunAfter Run after hooks of topic event, results are ignored
+func RunAfter(topic rdb.Rdb, event HookEvent, id string, document map[string]interface{}) {
+	for _, h := range findHooks(topic, event, AfterStage) {
+		_, err := h.Call(HookRequest{Topic: topic.Collection, Event: event, Id: id, Document: document})
+		if err != nil {
+			log.Warn("Hook " + h.Id.String() + " failed: " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package hook
+
+import (
+	err2 "db-server/err"
+	"db-server/server/db"
+	"db-server/utils"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+func AddAdminRoutes(admin *mux.Router) {
+	admin.HandleFunc("/hook", list).Methods(http.MethodGet, http.MethodOptions)               // each request calls PushHandler
+	admin.HandleFunc("/hook", create).Methods(http.MethodPost, http.MethodOptions)            // each request calls PushHandler
+	admin.HandleFunc("/hook/{id}", item).Methods(http.MethodGet, http.MethodOptions)          // each request calls PushHandler
+	admin.HandleFunc("/hook/{id}", deleteItem).Methods(http.MethodDelete, http.MethodOptions) // each request calls PushHandler
+	admin.HandleFunc("/hook/{id}", update).Methods(http.MethodPut, http.MethodOptions)        // each request calls PushHandler
+}
+
+// list godoc
+// @Summary      List topic hooks
+// @Description  List topic hooks
+// @Tags         Hook
+// @tags Admin
+// @Accept       json
+// @Produce      json
+// @Security bearerAuth
+// @Success      200  {array}   hook.Hook
+//
+// @Router       /admin/hook [get]
+func list(w http.ResponseWriter, r *http.Request) {
+	utils.ListItems(Hook{}, []string{"rdb_id"}, r, w)
+}
+
+// create
+// @Summary      Create topic hook
+// @Description  Create topic hook
+// @Tags         Hook
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        hook    body     hook.Hook  true  "Hook info" true
+// @Success      200 {object} hook.Hook
+// @Security bearerAuth
+//
+// @Router       /admin/hook [post]
+func create(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+	model := Hook{}
+
+	err := json.NewDecoder(r.Body).Decode(&model)
+	err2.DebugErr(err)
+	id, err := uuid.NewUUID()
+	model.Id = id
+
+	err2.DebugErr(err)
+
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	db.MetaDb.GetConnection().Create(&model)
+
+	resp, _ := json.Marshal(model)
+	w.WriteHeader(200)
+	_, err = w.Write(resp)
+	err2.DebugErr(err)
+}
+
+// item godoc
+// @Summary      Hook info
+// @Description  Hook detail info
+// @Tags         Hook
+// @tags Admin
+// @Accept       json
+// @Produce      json
+// @Param        id    path     string  true  "Hook id" id
+// @Security bearerAuth
+// @Success      200  {object}   hook.Hook
+//
+// @Router       /admin/hook/{id} [get]
+func item(w http.ResponseWriter, r *http.Request) {
+	utils.GetItem(Hook{}, w, r)
+}
+
+// deleteItem godoc
+// @Summary      Delete topic hook
+// @Description  Delete topic hook
+// @Tags         Hook
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        id    path     string  true  "Hook id" id
+// @Security bearerAuth
+// @Success      204
+//
+// @Router       /admin/hook/{id} [delete]
+func deleteItem(w http.ResponseWriter, r *http.Request) {
+	utils.DeleteItem(Hook{}, w, r)
+}
+
+// update
+// @Summary      Update topic hook
+// @Description  Update topic hook
+// @Tags         Hook
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        device    body     hook.Hook  true  "Hook info" true
+// @Param        id    path     string  true  "Hook id" id
+// @Success      200 {object} hook.Hook
+// @Security bearerAuth
+//
+// @Router       /admin/hook/{id} [put]
+func update(w http.ResponseWriter, r *http.Request) {
+	log.Debug(r.Method, r.RequestURI)
+	vars := mux.Vars(r)
+	exist, err := Hook{}.GetById(vars["id"])
+
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	newm := Hook{}
+
+	err = json.NewDecoder(r.Body).Decode(&newm)
+
+	newm.CreatedAt = exist.(Hook).CreatedAt
+
+	db.MetaDb.GetConnection().Save(&newm)
+
+	resp, _ := json.Marshal(newm)
+	w.WriteHeader(200)
+	_, err = w.Write(resp)
+	err2.DebugErr(err)
+}
@@ -8,7 +8,6 @@ import (
 	"errors"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
-	"os"
 	"time"
 )
 
@@ -98,7 +97,7 @@ func RunPipeline(inputName string, inputID uuid.UUID, data interface{}) {
 		case TopicOutput:
 			t, err := rdb.Rdb{}.GetById(source.OutputId.String())
 			if err == nil {
-				_ = server.SaveTopicMessage(os.Getenv("DB_NAME"), t.(rdb.Rdb).Collection, data)
+				_ = server.SaveTopicMessage(t.(rdb.Rdb), data)
 			}
 		case PluginOutput:
 			p, err := plugin.Plugin{}.GetById(source.OutputId.String())
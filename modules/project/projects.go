@@ -4,16 +4,21 @@ import (
 	"db-server/server/db"
 	"errors"
 	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"os"
+	"strings"
 	"time"
 )
 
 // swagger:model
 type Project struct {
-	Id        uuid.UUID      `gorm:"primarykey" json:"id"`
-	Name      string         `json:"name"`
-	Key       string         `json:"key"`
-	Origins   string         `json:"origins"`
+	Id      uuid.UUID `gorm:"primarykey" json:"id"`
+	Name    string    `json:"name"`
+	Key     string    `json:"key"`
+	Origins string    `json:"origins"`
+	// Project mongo database, generated from DB_NAME and project id when empty
+	DbName    string         `json:"db_name"`
 	CreatedAt time.Time      `json:"-"`
 	UpdatedAt time.Time      `json:"-"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
@@ -69,6 +74,29 @@ func (p Project) Delete(id string) {
 	conn.Where("id = ?", id).Delete(&p)
 }
 
+// ValidateOrigin Is request origin allowed by project origins list
+func (p Project) ValidateOrigin(origin string) bool {
+	pOrigins := strings.Split(p.Origins, ";")
+	for _, pOrigin := range pOrigins {
+		if pOrigin == "*" || pOrigin == origin {
+			return true
+		}
+	}
+
+	log.Debug("Invalid origin")
+
+	return false
+}
+
+// GetDbName Mongo database with project topics
+func (p Project) GetDbName() string {
+	if p.DbName != "" {
+		return p.DbName
+	}
+
+	return os.Getenv("DB_NAME") + "_" + strings.ReplaceAll(p.Id.String(), "-", "")
+}
+
 // TableName Gorm table name
 func (p Project) TableName() string {
 	return "project"
@@ -14,10 +14,16 @@ type Rdb struct {
 	ProjectId uuid.UUID `json:"project_id"`
 
 	Project    project.Project
-	Collection string         `json:"collection"`
-	CreatedAt  time.Time      `json:"-"`
-	UpdatedAt  time.Time      `json:"-"`
-	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+	Collection string `json:"collection"`
+	// Encrypted field paths separated by ";"
+	// example: phone;address.street
+	EncryptedFields string `json:"encrypted_fields"`
+	// Encrypted field paths with deterministic encryption, can be used in equality queries
+	// example: email
+	DeterministicFields string         `json:"deterministic_fields"`
+	CreatedAt           time.Time      `json:"-"`
+	UpdatedAt           time.Time      `json:"-"`
+	DeletedAt           gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 func (p Rdb) List(limit int, offset int, sort string, order string, filter map[string]string) ([]interface{}, error) {
@@ -61,6 +67,30 @@ func (p Rdb) GetByCollection(collection string) Rdb {
 	return source
 }
 
+func (p Rdb) GetByProjectCollection(projectId uuid.UUID, collection string) Rdb {
+	var source Rdb
+	conn := db.MetaDb.GetConnection()
+	conn.Preload("Project").First(&source, "project_id = ? AND collection = ?", projectId, collection)
+	return source
+}
+
+// GetDbName Mongo database of topic project
+func (p Rdb) GetDbName() string {
+	if p.Project.Id == uuid.Nil {
+		m, err := project.Project{}.GetById(p.ProjectId.String())
+		if err == nil {
+			p.Project = m.(project.Project)
+		}
+	}
+
+	return p.Project.GetDbName()
+}
+
+// Namespace Topic events key, unique across projects
+func (p Rdb) Namespace() string {
+	return p.GetDbName() + "." + p.Collection
+}
+
 // TableName Gorm table name
 func (p Rdb) TableName() string {
 	return "rdb"
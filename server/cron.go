@@ -10,7 +10,7 @@ type ServerCron struct {
 
 var Cron = ServerCron{}
 
-func (sc ServerCron) GetScheduler() *cron.Cron {
+func (sc *ServerCron) GetScheduler() *cron.Cron {
 	if sc.Cron == nil {
 		sc.Cron = cron.New()
 	}
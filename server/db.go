@@ -3,15 +3,66 @@ package server
 import (
 	"db-server/drivers"
 	"db-server/events"
+	"db-server/modules/crypt"
+	"db-server/modules/rdb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // SaveTopicMessage
-// Save document to db and register new message
-func SaveTopicMessage(db string, topic string, payload interface{}) error {
-	_, err := drivers.GetDbInstance().Insert(db, topic, payload)
+// Save document to topic project db and register new message.
+// Encrypted fields are stored encrypted, subscribers receive original payload
+func SaveTopicMessage(topic rdb.Rdb, payload interface{}) error {
+	stored, err := crypt.EncryptDocument(topic, payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = drivers.GetDbInstance().Insert(topic.GetDbName(), topic.Collection, stored)
 	if err == nil {
-		events.GetInstance().RegisterNewMessage(topic, payload)
+		events.GetInstance().RegisterNewMessage(topic.Namespace(), payload)
 	}
 
 	return err
 }
+
+// UpsertTopicMessage Replace topic document with key field value or insert new one.
+// Event is sent when document is inserted or changed
+func UpsertTopicMessage(topic rdb.Rdb, key string, payload map[string]interface{}) (*mongo.UpdateResult, error) {
+	filter, err := crypt.EncryptFilter(topic, map[string]interface{}{key: payload[key]})
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := crypt.EncryptDocument(topic, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := drivers.GetDbInstance().Upsert(topic.GetDbName(), topic.Collection, filter, stored)
+	if err == nil && (res.UpsertedCount > 0 || res.ModifiedCount > 0) {
+		events.GetInstance().RegisterNewMessage(topic.Namespace(), payload)
+	}
+
+	return res, err
+}
+
+// DeleteTopicMessagesExcept Remove topic documents with key field value not in values.
+// Documents without key field are kept
+func DeleteTopicMessagesExcept(topic rdb.Rdb, key string, values []interface{}) (int64, error) {
+	filter, err := crypt.EncryptFilter(topic, map[string]interface{}{key: map[string]interface{}{"$in": values}})
+	if err != nil {
+		return 0, err
+	}
+
+	// encrypted key values are listed in all key versions
+	stored := filter.(map[string]interface{})[key].(map[string]interface{})["$in"]
+
+	res, err := drivers.GetDbInstance().DeleteMany(topic.GetDbName(), topic.Collection,
+		bson.M{key: bson.M{"$exists": true, "$nin": stored}})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.DeletedCount, nil
+}
@@ -3,19 +3,24 @@ package server
 import (
 	"context"
 	err2 "db-server/err"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"strings"
 )
 
-func BuildDockerImage(tar io.Reader, tags []string) error {
+// BuildDockerImage Build image from tar build context with Dockerfile in its root.
+// Build output written to logs when not nil, failed build step returned as error
+func BuildDockerImage(tar io.Reader, tags []string, logs io.Writer) error {
 	cli, err := GetDockerCli()
-	err2.DebugErr(err)
+	if err != nil {
+		return err
+	}
 
 	ctx := context.Background()
 
@@ -27,28 +32,39 @@ func BuildDockerImage(tar io.Reader, tags []string) error {
 	}
 
 	res, err := cli.ImageBuild(ctx, tar, opts)
+	if err != nil {
+		log.Debug(err)
+		return err
+	}
 
 	defer func() {
 		err := res.Body.Close()
 		err2.DebugErr(err)
 	}()
 
-	buf := new(strings.Builder)
-	_, err = io.Copy(buf, res.Body)
-
-	if err != nil {
-		log.Debug(err)
-		return err
+	if logs == nil {
+		logs = io.Discard
 	}
 
-	// check errors
-	fmt.Println(buf.String())
-
-	if buf.String() != "" {
-		return errors.New(buf.String())
+	decoder := json.NewDecoder(res.Body)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if msg.Error != nil {
+			return msg.Error
+		}
+
+		if msg.Stream != "" {
+			_, err = io.WriteString(logs, msg.Stream)
+			err2.DebugErr(err)
+		}
 	}
-
-	return nil
 }
 
 func PullDockerImage(refStr string) {
@@ -66,6 +82,15 @@ func PullDockerImage(refStr string) {
 }
 
 func CreateDockerContainer(image string, cmd []string, env []string) (string, error) {
+	return CreateDockerContainerFromConfig(&container.Config{
+		Env:   env,   //strings.Split(p.Env, "\n"),
+		Image: image, //uri.Image,
+		Cmd:   cmd,   //prepareDockerParams(p.Params),
+	}, nil)
+}
+
+// CreateDockerContainerFromConfig Create container with full container and host config
+func CreateDockerContainerFromConfig(config *container.Config, hostConfig *container.HostConfig) (string, error) {
 	ctx := context.Background()
 	cli, err := GetDockerCli()
 
@@ -73,13 +98,9 @@ func CreateDockerContainer(image string, cmd []string, env []string) (string, er
 		return "", err
 	}
 
-	resp, err := cli.ContainerCreate(ctx, &container.Config{
-		Env:   env,   //strings.Split(p.Env, "\n"),
-		Image: image, //uri.Image,
-		Cmd:   cmd,   //prepareDockerParams(p.Params),
-	}, nil, nil, nil, "")
+	resp, err := cli.ContainerCreate(ctx, config, hostConfig, nil, nil, "")
 
-	return resp.ID, nil
+	return resp.ID, err
 }
 
 func GetDockerCli() (*client.Client, error) {
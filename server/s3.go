@@ -77,3 +77,13 @@ func getSize(stream io.Reader) int64 {
 
 	return int64(buf.Len())
 }
+
+// GetFromS3 Open object from storage bucket
+func GetFromS3(path string) (io.ReadCloser, error) {
+	minioClient, err := getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return minioClient.GetObject(context.Background(), os.Getenv("STORAGE_BUCKET"), path, minio.GetObjectOptions{})
+}
@@ -6,8 +6,10 @@ import (
 	"db-server/modules/cf"
 	"db-server/modules/config"
 	"db-server/modules/cron"
+	"db-server/modules/crypt"
 	"db-server/modules/ds"
 	"db-server/modules/em"
+	"db-server/modules/hook"
 	"db-server/modules/oauth"
 	"db-server/modules/pipeline"
 	"db-server/modules/plugin"
@@ -91,6 +93,8 @@ func StartServer(enableDocs *bool) {
 	push.AddAdminRoutes(admin)
 	cron.AddAdminRoutes(admin)
 	plugin.AddAdminRoutes(admin)
+	hook.AddAdminRoutes(admin)
+	crypt.AddAdminRoutes(admin)
 
 	push.AddPublicApiRoutes(r)
 	oauth.AddPublicApiRoutes(r)
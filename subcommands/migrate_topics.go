@@ -0,0 +1,107 @@
+package subcommands
+
+import (
+	"context"
+	"db-server/drivers"
+	"db-server/modules/rdb"
+	"db-server/server/db"
+	"flag"
+	"fmt"
+	"github.com/google/subcommands"
+	"gorm.io/gorm/clause"
+	"os"
+	"slices"
+	"strings"
+)
+
+type MigrateTopics struct {
+	dryRun bool
+}
+
+func (*MigrateTopics) Name() string     { return "migrate-topics" }
+func (*MigrateTopics) Synopsis() string { return "Move topics to project databases" }
+func (*MigrateTopics) Usage() string {
+	return `migrate-topics [-dry-run]:
+  Move topic collections from DB_NAME database to project databases.
+`
+}
+
+func (p *MigrateTopics) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&p.dryRun, "dry-run", false, "Print moves without changes")
+}
+
+func (p *MigrateTopics) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	sourceDb := os.Getenv("DB_NAME")
+	status := subcommands.ExitSuccess
+
+	conn := db.MetaDb.GetConnection()
+
+	var total int64
+	conn.Model(&rdb.Rdb{}).Count(&total)
+
+	var topics []rdb.Rdb
+	offset := 0
+	batchSize := 20
+
+	for {
+		var batch []rdb.Rdb
+		err := conn.Preload("Project").
+			Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}}).
+			Limit(batchSize).
+			Offset(offset).
+			Find(&batch).Error
+		if err != nil {
+			fmt.Println(err.Error())
+			return subcommands.ExitFailure
+		}
+
+		if len(batch) <= 0 {
+			break
+		}
+
+		topics = append(topics, batch...)
+		offset += batchSize
+	}
+
+	if int64(len(topics)) != total {
+		fmt.Printf("Loaded %d of %d topics, nothing moved\n", len(topics), total)
+		return subcommands.ExitFailure
+	}
+
+	// one source collection can be moved to one project only
+	projects := make(map[string][]string)
+	for _, topic := range topics {
+		if !slices.Contains(projects[topic.Collection], topic.GetDbName()) {
+			projects[topic.Collection] = append(projects[topic.Collection], topic.GetDbName())
+		}
+	}
+
+	for collection, dbs := range projects {
+		if len(dbs) > 1 {
+			fmt.Printf("%s.%s is shared by %s, not moved\n", sourceDb, collection, strings.Join(dbs, ", "))
+			status = subcommands.ExitFailure
+		}
+	}
+
+	for _, topic := range topics {
+		targetDb := topic.GetDbName()
+
+		if len(projects[topic.Collection]) > 1 {
+			continue
+		}
+
+		fmt.Printf("%s.%s -> %s.%s\n", sourceDb, topic.Collection, targetDb, topic.Collection)
+
+		if p.dryRun || targetDb == sourceDb {
+			continue
+		}
+
+		err := drivers.GetDbInstance().MoveCollection(sourceDb, topic.Collection, targetDb, topic.Collection)
+		if err != nil {
+			fmt.Println("  " + err.Error())
+			status = subcommands.ExitFailure
+		}
+	}
+
+	return status
+}